@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ResponseCache stores the responses of version lookups in a directory along
+// with their ETag and Last-Modified validators. Cached responses are
+// revalidated with conditional requests, which e.g. GitHub doesn't count
+// against the rate limit, and reused if the server responds with
+// `304 Not Modified`.
+type ResponseCache struct {
+	// Dir is the cache directory. Caching is disabled if it is empty.
+	Dir string
+	// Refresh ignores cached responses and fetches them again.
+	Refresh bool
+}
+
+// cachedResponse is a cached response as stored in the cache directory.
+type cachedResponse struct {
+	URL          string      `json:"url"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"lastModified,omitempty"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+}
+
+// Client returns a client that caches the GET responses of the given client.
+func (c ResponseCache) Client(client *http.Client) *http.Client {
+	if c.Dir == "" {
+		return client
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	cached := *client
+	cached.Transport = &cachingTransport{RoundTripper: transport, cache: c}
+	return &cached
+}
+
+// path returns the cache file of the url.
+func (c ResponseCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c ResponseCache) load(url string) (*cachedResponse, error) {
+	data, err := os.ReadFile(c.path(url))
+	if err != nil {
+		return nil, err
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if entry.URL != url {
+		return nil, fmt.Errorf("cached url mismatch: %s", entry.URL)
+	}
+	return &entry, nil
+}
+
+func (c ResponseCache) store(entry cachedResponse) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, lookups may run concurrently.
+	file, err := os.CreateTemp(c.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), c.path(entry.URL))
+}
+
+// cachingTransport serves GET requests from the cache if the server confirms
+// that the cached response is still valid.
+type cachingTransport struct {
+	http.RoundTripper
+	cache ResponseCache
+}
+
+func (t *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	url := req.URL.String()
+
+	var entry *cachedResponse
+	if !t.cache.Refresh {
+		entry, _ = t.cache.load(url) // a missing or broken entry is refetched
+	}
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.ETag != "" && req.Header.Get("If-None-Match") == "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" && req.Header.Get("If-Modified-Since") == "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		slog.Debug("using cached response", "url", url)
+		_ = resp.Body.Close()
+
+		// Headers of the 304 response, e.g. rate limits, update the cached ones.
+		header := entry.Header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		for key, values := range resp.Header {
+			header[key] = values
+		}
+		header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = header
+		resp.ContentLength = int64(len(entry.Body))
+		resp.Body = io.NopCloser(bytes.NewReader(entry.Body))
+		return resp, nil
+
+	case resp.StatusCode == http.StatusOK:
+		etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if etag == "" && lastModified == "" {
+			return resp, nil
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = t.cache.store(cachedResponse{
+			URL:          url,
+			ETag:         etag,
+			LastModified: lastModified,
+			Header:       resp.Header.Clone(),
+			Body:         body,
+		})
+		if err != nil {
+			slog.Warn("failed to cache response", "url", url, "error", err)
+		}
+		return resp, nil
+
+	default:
+		return resp, nil
+	}
+}
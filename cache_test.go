@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResponseCache(t *testing.T) {
+	var requests, notModified int
+	etag := `"v1"`
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /releases", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/releases?page=2>; rel="next"`, srv.URL))
+		}
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "" {
+			fmt.Fprintf(w, `[{"tag_name": "v1.1.0"}]`)
+		} else {
+			fmt.Fprintf(w, `[{"tag_name": "v1.0.0"}]`)
+		}
+	})
+
+	dir := t.TempDir()
+	query := VersionsQuery{JSONPath: "$[*].tag_name"}
+	getVersions := func(cache ResponseCache) []string {
+		t.Helper()
+		versions, err := GetVersions(context.Background(), cache.Client(srv.Client()), srv.URL+"/releases", query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return versions
+	}
+
+	tests := []struct {
+		testName        string
+		cache           ResponseCache
+		etag            string
+		want            []string
+		wantNotModified int
+	}{
+		{testName: "fetch", cache: ResponseCache{Dir: dir}, etag: `"v1"`, want: []string{"v1.1.0", "v1.0.0"}},
+		{testName: "revalidate", cache: ResponseCache{Dir: dir}, etag: `"v1"`, want: []string{"v1.1.0", "v1.0.0"}, wantNotModified: 2},
+		{testName: "refresh", cache: ResponseCache{Dir: dir, Refresh: true}, etag: `"v1"`, want: []string{"v1.1.0", "v1.0.0"}},
+		{testName: "changed", cache: ResponseCache{Dir: dir}, etag: `"v2"`, want: []string{"v1.1.0", "v1.0.0"}},
+		{testName: "disabled", cache: ResponseCache{}, etag: `"v2"`, want: []string{"v1.1.0", "v1.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			requests, notModified, etag = 0, 0, tt.etag
+			got := getVersions(tt.cache)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("GetVersions() = %v, want %v", got, tt.want)
+			}
+			if requests != 2 {
+				t.Errorf("expected 2 requests, got %d", requests)
+			}
+			if notModified != tt.wantNotModified {
+				t.Errorf("expected %d not modified responses, got %d", tt.wantNotModified, notModified)
+			}
+		})
+	}
+}
@@ -48,6 +48,8 @@ func configure() *cli.Command {
 			cli.DefaultVersionCommand(os.Stdout),
 			newInstallCmd(),
 			newLockCmd(),
+			newUseCmd(),
+			newPruneCmd(),
 		},
 		Flags: fs,
 		Exec:  cfg.Exec,
@@ -149,6 +151,36 @@ func (c *rootCmd) loadConfig() (Config, error) {
 	return cfg, nil
 }
 
+// initResolver sets up the resolver with the configured providers and
+// minimum age, and the user's auth tokens, denylist and cache directory.
+func initResolver(r *Resolver, cfg Config) error {
+	configDir := xdgDir(xdgConfigHome)
+
+	tokens, err := LoadAuthTokens(filepath.Join(configDir, "auth.yaml"))
+	if err != nil {
+		return fmt.Errorf("load auth tokens: %w", err)
+	}
+	providers, err := InitProviders(append(builtinProviderSpecs, cfg.Providers...), tokens)
+	if err != nil {
+		return fmt.Errorf("init providers: %w", err)
+	}
+	r.Providers = providers
+
+	if cfg.Global.MinAge != "" {
+		if r.MinAge, err = parseAge(cfg.Global.MinAge); err != nil {
+			return fmt.Errorf("parse min age: %w", err)
+		}
+	}
+
+	r.Denylist, err = LoadDenylist(filepath.Join(configDir, "denylist.yaml"))
+	if err != nil {
+		return fmt.Errorf("load denylist: %w", err)
+	}
+
+	r.Cache.Dir = filepath.Join(xdgDir(xdgCacheHome), "http")
+	return nil
+}
+
 // xdgHomeKind represents the kind of XDG home directory.
 type xdgHomeKind string
 
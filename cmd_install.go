@@ -1,7 +1,9 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -9,10 +11,10 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
-	"sync"
 
 	"github.com/cluttrdev/cli"
 	"github.com/pterm/pterm"
+	"golang.org/x/sync/errgroup"
 
 	"go.cluttr.dev/prebuilt/internal/metaerr"
 )
@@ -38,14 +40,30 @@ type installCmd struct {
 
 	resolver Resolver
 
+	// newStatus creates the printer that reports the installation status of
+	// a single binary. It defaults to spinners.
+	newStatus func(name string) statusPrinter
+
 	// flags
 	update bool
+	atomic bool
+}
+
+// statusPrinter reports the installation status of a single binary.
+type statusPrinter interface {
+	Success(message ...any)
+	Fail(message ...any)
 }
 
 func (c *installCmd) RegisterFlags(fs *flag.FlagSet) {
 	c.rootCmd.RegisterFlags(fs)
 
 	fs.BoolVar(&c.update, "update", false, "Update versions in lock file.")
+	fs.BoolVar(&c.atomic, "atomic", false, "Install all binaries or none of them.")
+	fs.IntVar(&c.resolver.Jobs, "jobs", defaultJobs, "The maximum number of binaries to process concurrently.")
+	fs.BoolVar(&c.resolver.IgnoreMinAge, "ignore-min-age", false, "Ignore the minimum age of versions, e.g. for urgent security fixes.")
+	fs.BoolVar(&c.resolver.Cache.Refresh, "refresh", false, "Ignore cached version lookups and fetch them again.")
+	fs.DurationVar(&c.resolver.RateLimitWait, "rate-limit-wait", 0, "The maximum time to wait for exceeded API rate limits to reset instead of failing.")
 }
 
 func (c *installCmd) Exec(ctx context.Context, args []string) (err error) {
@@ -63,17 +81,9 @@ func (c *installCmd) Exec(ctx context.Context, args []string) (err error) {
 		return err
 	}
 
-	authFile := filepath.Join(xdgDir(xdgConfigHome), "auth.yaml")
-	tokens, err := LoadAuthTokens(authFile)
-	if err != nil {
-		return fmt.Errorf("load auth tokens: %w", err)
-	}
-
-	providers, err := InitProviders(append(builtinProviderSpecs, cfg.Providers...), tokens)
-	if err != nil {
-		return fmt.Errorf("init providers: %w", err)
+	if err := initResolver(&c.resolver, cfg); err != nil {
+		return err
 	}
-	c.resolver.Providers = providers
 
 	lock, err := c.getLock(ctx, cfg.Binaries, c.update)
 	if err != nil {
@@ -95,6 +105,47 @@ func (c *installCmd) Exec(ctx context.Context, args []string) (err error) {
 		binaries = lock.Binaries
 	}
 
+	installDir := expandPath(cfg.Global.InstallDir)
+	if err := c.applyFileOwnership(cfg, binaries); err != nil {
+		return err
+	}
+	if err := registerInstallDir(installDir); err != nil {
+		return fmt.Errorf("register install directory: %w", err)
+	}
+
+	return c.install(ctx, binaries, installDir)
+}
+
+// applyFileOwnership sets the file mode and group of the binaries from their
+// configuration, falling back to the global defaults. They are not part of
+// the lock, so that changes apply without updating it.
+func (c *installCmd) applyFileOwnership(cfg Config, binaries []BinaryData) error {
+	specs := make(map[string]BinarySpec, len(cfg.Binaries))
+	for _, bin := range cfg.Binaries {
+		name, err := c.resolver.BinaryName(bin)
+		if err != nil {
+			continue // not part of the lock either
+		}
+		specs[name] = bin
+	}
+
+	for i := range binaries {
+		spec := specs[binaries[i].Name]
+		binaries[i].Mode = cmp.Or(spec.Mode, cfg.Global.Mode)
+		binaries[i].Group = cmp.Or(spec.Group, cfg.Global.Group)
+		if _, _, err := parseFileMode(binaries[i].Mode); err != nil {
+			return fmt.Errorf("%s: %w", binaries[i].Name, err)
+		}
+		if _, err := lookupGroup(binaries[i].Group); err != nil {
+			return fmt.Errorf("%s: %w", binaries[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// install installs the given binaries concurrently.
+// Unless in atomic mode, a failing binary doesn't affect the others.
+func (c *installCmd) install(ctx context.Context, binaries []BinaryData, installDir string) error {
 	tmpDir, err := os.MkdirTemp("", "prebuilt-*")
 	if err != nil {
 		return fmt.Errorf("create temp dir: %w", err)
@@ -105,40 +156,87 @@ func (c *installCmd) Exec(ctx context.Context, args []string) (err error) {
 		}
 	}()
 
-	installDir := expandPath(cfg.Global.InstallDir)
-
 	var (
-		failedSpecs []BinaryData
-		wg          sync.WaitGroup
+		staged = make([]stagedBinary, len(binaries))
+		errs   = make([]error, len(binaries))
+	)
+	defer func() {
+		for _, s := range staged {
+			if err := s.discard(); err != nil {
+				slog.Error("failed to remove staged binary", "name", s.name, "error", err)
+			}
+		}
+	}()
 
+	newStatus := c.newStatus
+	if newStatus == nil {
 		// for fancy output
-		multiPrinter = pterm.DefaultMultiPrinter
-	)
-	_, _ = multiPrinter.Start()
-	for _, data := range binaries {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			spinner, _ := pterm.DefaultSpinner.WithWriter(multiPrinter.NewWriter()).Start("Installing ", data.Name)
-			if err := c.processBinary(ctx, data, tmpDir, installDir); err != nil {
+		multiPrinter := pterm.DefaultMultiPrinter
+		_, _ = multiPrinter.Start()
+		defer func() {
+			_, _ = multiPrinter.Stop()
+		}()
+
+		newStatus = func(name string) statusPrinter {
+			spinner, _ := pterm.DefaultSpinner.WithWriter(multiPrinter.NewWriter()).Start("Installing ", name)
+			return spinner
+		}
+	}
+
+	// in atomic mode, the first failure cancels all remaining binaries
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var g errgroup.Group
+	g.SetLimit(c.resolver.jobs())
+
+	for i, data := range binaries {
+		// the multi printer is not safe for concurrent use
+		spinner := newStatus(data.Name)
+		g.Go(func() error {
+			staged[i], errs[i] = c.processBinary(ctx, data, tmpDir, installDir)
+			if err := errs[i]; errors.Is(err, context.Canceled) {
+				spinner.Fail("Cancelled ", data.Name)
+				return nil
+			} else if err != nil {
 				slog.With("name", data.Name, "error", err).
 					With(metaerr.GetMetadata(err)...).
 					Error("failed to install binary")
-				failedSpecs = append(failedSpecs, data)
 				spinner.Fail("Failed to install ", data.Name, ": ", err)
-				return
+				if c.atomic {
+					cancel()
+				}
+				return nil
 			}
-			spinner.Success()
-		}()
+			if c.atomic {
+				spinner.Success("Staged ", data.Name)
+			} else {
+				spinner.Success()
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, binaries[i].Name)
+		}
 	}
-	wg.Wait()
-	_, _ = multiPrinter.Stop()
-	if len(failedSpecs) > 0 {
-		names := make([]string, 0, len(failedSpecs))
-		for _, spec := range failedSpecs {
-			names = append(names, spec.Name)
+	if len(failed) > 0 {
+		if c.atomic {
+			return fmt.Errorf("installation failed, no binaries installed: %v", failed)
+		}
+		return fmt.Errorf("installation failed: %v", failed)
+	}
+
+	if c.atomic {
+		if err := commitAll(staged); err != nil {
+			slog.Error("failed to commit staged binaries", "error", err)
+			return fmt.Errorf("installation failed, no binaries installed: %w", err)
 		}
-		return fmt.Errorf("installation failed: %v", names)
+		pterm.Success.Printfln("Installed %d binaries", len(staged))
 	}
 
 	return nil
@@ -149,6 +247,8 @@ func (c *installCmd) getLock(ctx context.Context, binaries []BinarySpec, update
 
 	lockfile := replaceFileExt(c.ConfigFile, ".lock")
 	if _, err := os.Stat(lockfile); os.IsNotExist(err) || update {
+		var skipped skippedReleases
+		c.resolver.OnSkip = skipped.add
 		spinner, _ := pterm.DefaultSpinner.Start("Resolving binaries")
 		lock, err = c.resolver.Resolve(ctx, binaries)
 		if err != nil {
@@ -156,9 +256,11 @@ func (c *installCmd) getLock(ctx context.Context, binaries []BinarySpec, update
 				With(metaerr.GetMetadata(err)...).
 				Error("failed to resolve binaries")
 			spinner.Fail()
+			skipped.print()
 			return Lock{}, err
 		}
 		spinner.Success()
+		skipped.print()
 		if err := writeLockFile(lockfile, lock); err != nil {
 			slog.Error("failed to write lock file", "error", err)
 		}
@@ -172,34 +274,316 @@ func (c *installCmd) getLock(ctx context.Context, binaries []BinarySpec, update
 	return lock, nil
 }
 
-func (c *installCmd) processBinary(ctx context.Context, data BinaryData, tmpDir string, installDIr string) error {
-	client := c.resolver.Client(data.Provider)
+// stagedBinary is a binary that has been placed into a temporary directory
+// next to its version directory in the store, but which has neither been
+// moved into place nor linked into the install directory yet. This keeps an
+// installed version intact until the staged one is committed.
+type stagedBinary struct {
+	name string
+	// dir is the temporary directory the binary has been staged in
+	dir string
+	// versionDir is the directory in the store that dir replaces
+	versionDir string
+	links      []stagedLink
+}
+
+type stagedLink struct {
+	target string
+	link   string
+}
+
+// commit moves the staged directory into the store and switches the binary's
+// links in the install directory to the staged targets. The returned
+// function restores the previous version directory and links.
+func (s stagedBinary) commit() (func() error, error) {
+	var restores []func() error
+	restore := func() error {
+		var errs []error
+		for i := len(restores) - 1; i >= 0; i-- {
+			errs = append(errs, restores[i]())
+		}
+		return errors.Join(errs...)
+	}
+
+	if s.dir != "" {
+		r, err := SwapDir(s.dir, s.versionDir)
+		if err != nil {
+			return nil, fmt.Errorf("store %s: %w", s.name, err)
+		}
+		restores = append(restores, r)
+	}
+
+	for _, l := range s.links {
+		r, err := SwapLink(l.target, l.link)
+		if err != nil {
+			if rerr := restore(); rerr != nil {
+				err = errors.Join(err, fmt.Errorf("restore previous links: %w", rerr))
+			}
+			return nil, fmt.Errorf("link %s: %w", s.name, err)
+		}
+		restores = append(restores, r)
+	}
+
+	return restore, nil
+}
+
+// discard removes the staged directory, unless it has been committed, as well
+// as the backup of the version directory it replaced.
+func (s stagedBinary) discard() error {
+	if s.dir == "" {
+		return nil
+	}
+	return errors.Join(os.RemoveAll(s.dir), os.RemoveAll(backupPath(s.versionDir)))
+}
+
+// commitAll commits all staged binaries. If any of them fails, the links of
+// the ones already committed are restored.
+func commitAll(staged []stagedBinary) error {
+	var restores []func() error
+	for _, s := range staged {
+		restore, err := s.commit()
+		if err != nil {
+			for i := len(restores) - 1; i >= 0; i-- {
+				if rerr := restores[i](); rerr != nil {
+					err = errors.Join(err, fmt.Errorf("restore previous links: %w", rerr))
+				}
+			}
+			return err
+		}
+		restores = append(restores, restore)
+	}
+	return nil
+}
+
+// processBinary stages the binary and, unless in atomic mode, commits it
+// right away.
+func (c *installCmd) processBinary(ctx context.Context, data BinaryData, tmpDir string, installDir string) (stagedBinary, error) {
+	if err := ctx.Err(); err != nil {
+		return stagedBinary{}, err
+	}
+
+	// use a separate directory, so that equally named assets don't collide
+	dir, err := os.MkdirTemp(tmpDir, "")
+	if err != nil {
+		return stagedBinary{}, fmt.Errorf("create temp dir: %w", err)
+	}
+
+	staged, err := c.stageBinary(ctx, data, dir, installDir)
+	if err != nil || c.atomic {
+		return staged, err
+	}
+	_, err = staged.commit()
+	return staged, err
+}
+
+// stageBinary downloads the binary and installs it into a temporary directory
+// within the store.
+func (c *installCmd) stageBinary(ctx context.Context, data BinaryData, tmpDir string, installDIr string) (stagedBinary, error) {
+	client := c.resolver.Client(data.Provider, data.Instance)
 	if client == nil {
-		return fmt.Errorf("missing provider client: %s", data.Provider)
+		return stagedBinary{}, fmt.Errorf("missing provider client: %s", data.Provider)
 	}
 
-	path, err := Download(ctx, client, data.DownloadURL, tmpDir)
+	path, err := Download(withSigningRegion(ctx, data.Region), client, data.DownloadURL, tmpDir, data.Filename)
 	if err != nil {
-		return metaerr.WithMetadata(
+		return stagedBinary{}, metaerr.WithMetadata(
 			fmt.Errorf("download binary asset: %w", err),
 			"url", data.DownloadURL,
 		)
 	}
+	if data.Checksum != "" {
+		if err := VerifyChecksum(path, data.Checksum); err != nil {
+			return stagedBinary{}, metaerr.WithMetadata(
+				fmt.Errorf("verify binary asset: %w", err),
+				"url", data.DownloadURL,
+			)
+		}
+	}
+
+	mode, preserve, err := parseFileMode(data.Mode)
+	if err != nil {
+		return stagedBinary{}, err
+	}
+	gid, err := lookupGroup(data.Group)
+	if err != nil {
+		return stagedBinary{}, err
+	}
+
+	versionDir, err := storeDir(data.Name, data.Version)
+	if err != nil {
+		return stagedBinary{}, err
+	}
+	stagedDir := filepath.Join(filepath.Dir(versionDir), fmt.Sprintf(".%s.staged", filepath.Base(versionDir)))
+	// delete left-over staged directory
+	if err := os.RemoveAll(stagedDir); err != nil {
+		return stagedBinary{}, fmt.Errorf("create store directory: %w", err)
+	}
+	if err := os.MkdirAll(stagedDir, os.ModePerm); err != nil {
+		return stagedBinary{}, fmt.Errorf("create store directory: %w", err)
+	}
+	staged := stagedBinary{name: data.Name, dir: stagedDir, versionDir: versionDir}
+	defer func() {
+		if err != nil {
+			_ = staged.discard()
+		}
+	}()
+
+	if data.Layout == layoutTree {
+		staged.links, err = c.stageTree(data, path, staged, installDIr, mode, preserve, gid)
+		return staged, err
+	}
 
 	// Extract
 	if data.ExtractPath != "" {
-		path, err = Extract(path, data.ExtractPath)
+		var perm os.FileMode
+		path, perm, err = Extract(path, data.ExtractPath)
 		if err != nil {
-			return fmt.Errorf("extract archived binary: %w", err)
+			return stagedBinary{}, fmt.Errorf("extract archived binary: %w", err)
+		}
+		if preserve {
+			mode = perm
 		}
 	}
 
 	// Install
-	out := filepath.Join(installDIr, data.Name)
-	if err := Install(path, out); err != nil {
-		return fmt.Errorf("install binary: %w", err)
+	stored := filepath.Join(stagedDir, data.Name)
+	if err = Install(path, stored, mode); err != nil {
+		return stagedBinary{}, fmt.Errorf("install binary: %w", err)
+	}
+	if err = ChownGroup(stored, gid); err != nil {
+		return stagedBinary{}, fmt.Errorf("set group: %w", err)
 	}
 
+	staged.links = []stagedLink{
+		{target: filepath.Join(versionDir, data.Name), link: filepath.Join(installDIr, data.Name)},
+	}
+	return staged, nil
+}
+
+// stageTree installs the archived directory tree of a binary into the staged
+// directory and determines the executables to link into the install
+// directory.
+// A non-zero mode is applied to the linked executables, a non-negative group
+// id to the whole tree.
+func (c *installCmd) stageTree(data BinaryData, archive string, staged stagedBinary, installDir string, mode os.FileMode, preserve bool, gid int) ([]stagedLink, error) {
+	if err := InstallTree(archive, staged.dir, data.StripComponents, preserve); err != nil {
+		return nil, fmt.Errorf("install tree: %w", err)
+	}
+	if err := ChownGroup(staged.dir, gid); err != nil {
+		return nil, fmt.Errorf("set group: %w", err)
+	}
+
+	bins := data.Bins
+	if len(bins) == 0 {
+		var err error
+		bins, err = treeExecutables(staged.dir)
+		if err != nil {
+			return nil, fmt.Errorf("find executables: %w", err)
+		}
+	}
+
+	var links []stagedLink
+	for _, bin := range bins {
+		path := filepath.FromSlash(bin)
+		if _, err := os.Stat(filepath.Join(staged.dir, path)); err != nil {
+			return nil, fmt.Errorf("link executable: %w", err)
+		}
+		if mode != 0 {
+			if err := os.Chmod(filepath.Join(staged.dir, path), mode); err != nil {
+				return nil, fmt.Errorf("set file mode: %w", err)
+			}
+		}
+		links = append(links, stagedLink{
+			target: filepath.Join(staged.versionDir, path),
+			link:   filepath.Join(installDir, filepath.Base(path)),
+		})
+	}
+
+	return links, nil
+}
+
+// storePath joins the given path elements to the root of the versioned store.
+func storePath(elem ...string) string {
+	return filepath.Join(append([]string{xdgDir(xdgDataHome), "store"}, elem...)...)
+}
+
+// installDirsPath returns the file that records the install directories
+// linking into the store, one absolute path per line. The store is shared
+// between configurations, so all of them are needed to tell which versions
+// are still in use.
+func installDirsPath() string {
+	return filepath.Join(xdgDir(xdgDataHome), "installdirs")
+}
+
+// registerInstallDir records the install directory, unless it is known
+// already.
+func registerInstallDir(dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	dirs, err := installDirs()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(dirs, dir) {
+		return nil
+	}
+
+	path := installDirsPath()
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, dir); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// installDirs returns the recorded install directories.
+func installDirs() ([]string, error) {
+	data, err := os.ReadFile(installDirsPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" && !slices.Contains(dirs, line) {
+			dirs = append(dirs, line)
+		}
+	}
+	return dirs, nil
+}
+
+// storeDir returns the directory in which the given version of a binary is
+// stored. Names and versions that don't map to a single directory within the
+// store are rejected.
+func storeDir(name string, version string) (string, error) {
+	if err := checkStoreName(name); err != nil {
+		return "", err
+	}
+	dir := strings.ReplaceAll(version, "/", "_")
+	if err := checkStoreName(dir); err != nil {
+		return "", fmt.Errorf("invalid version: %q", version)
+	}
+	return storePath(name, dir), nil
+}
+
+// checkStoreName returns an error unless the name is a single path component
+// that can be used as a directory within the store. Hidden names are
+// rejected as well, they are reserved for temporary files.
+func checkStoreName(name string) error {
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid name: %q", name)
+	}
 	return nil
 }
 
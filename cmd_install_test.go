@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_installCmd_install(t *testing.T) {
+	_, prov := setupFakeProvider(t)
+
+	tests := []struct {
+		testName string
+		atomic   bool
+		broken   int
+		wantErr  bool
+	}{
+		{testName: "all succeed"},
+		{testName: "some fail", broken: 2, wantErr: true},
+		{testName: "atomic", atomic: true},
+		{testName: "atomic some fail", atomic: true, broken: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Setenv("PREBUILT_DATA_HOME", t.TempDir())
+			installDir := t.TempDir()
+
+			c := installCmd{
+				resolver: Resolver{
+					Providers: map[string]*Provider{"fake": prov},
+					Jobs:      3,
+				},
+				newStatus: func(string) statusPrinter { return nopStatus{} },
+				atomic:    tt.atomic,
+			}
+
+			var binaries []BinaryData
+			for i := range 12 {
+				repo := fmt.Sprintf("repo%02d", i)
+				if i < tt.broken {
+					repo = "broken"
+				}
+				binaries = append(binaries, BinaryData{
+					Name:        fmt.Sprintf("bin%02d", i),
+					Provider:    "fake",
+					Version:     "v1.0.0",
+					DownloadURL: fmt.Sprintf("%s/download/owner/%s/v1.0.0/tool", urlBase(prov), repo),
+				})
+			}
+
+			err := c.install(context.Background(), binaries, installDir)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("install() failed: %v", err)
+				}
+			} else if tt.wantErr {
+				t.Fatal("install() succeeded unexpectedly")
+			}
+
+			for i, data := range binaries {
+				content, err := os.ReadFile(filepath.Join(installDir, data.Name))
+				installed := err == nil
+				if wantInstalled := i >= tt.broken && !(tt.atomic && tt.wantErr); installed != wantInstalled {
+					t.Errorf("%s installed = %v, want %v", data.Name, installed, wantInstalled)
+					continue
+				}
+				if want := fmt.Sprintf("owner/repo%02d@v1.0.0", i); installed && string(content) != want {
+					t.Errorf("%s content = %q, want %q", data.Name, content, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_installCmd_install_atomicReinstall(t *testing.T) {
+	_, prov := setupFakeProvider(t)
+	t.Setenv("PREBUILT_DATA_HOME", t.TempDir())
+	installDir := t.TempDir()
+
+	c := installCmd{
+		resolver: Resolver{
+			Providers: map[string]*Provider{"fake": prov},
+			Jobs:      1,
+		},
+		newStatus: func(string) statusPrinter { return nopStatus{} },
+	}
+	active := BinaryData{
+		Name:        "tool",
+		Provider:    "fake",
+		Version:     "v1.0.0",
+		DownloadURL: urlBase(prov) + "/download/owner/tool/v1.0.0/tool",
+	}
+	if err := c.install(context.Background(), []BinaryData{active}, installDir); err != nil {
+		t.Fatalf("install() failed: %v", err)
+	}
+	// mark the installed binary to tell whether it is rewritten
+	versionDir, _ := storeDir("tool", "v1.0.0")
+	if err := os.WriteFile(filepath.Join(versionDir, "tool"), []byte("installed"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// reinstall the active version along with a failing binary
+	c.atomic = true
+	broken := BinaryData{
+		Name:        "other",
+		Provider:    "fake",
+		Version:     "v1.0.0",
+		DownloadURL: urlBase(prov) + "/download/owner/broken/v1.0.0/tool",
+	}
+	if err := c.install(context.Background(), []BinaryData{active, broken}, installDir); err == nil {
+		t.Fatal("install() succeeded unexpectedly")
+	}
+
+	if content, err := os.ReadFile(filepath.Join(installDir, "tool")); err != nil || string(content) != "installed" {
+		t.Errorf("tool content = %q, %v, want %q", content, err, "installed")
+	}
+	entries, err := os.ReadDir(storePath("tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("store entries = %d, want only the installed version", len(entries))
+	}
+}
+
+// urlBase returns the base url of the fake provider's download url.
+func urlBase(prov *Provider) string {
+	u := urlPath(prov.Spec.DownloadURL)
+	return prov.Spec.DownloadURL[:len(prov.Spec.DownloadURL)-len(u)]
+}
+
+type nopStatus struct{}
+
+func (nopStatus) Success(...any) {}
+func (nopStatus) Fail(...any)    {}
+
+func Test_installCmd_applyFileOwnership(t *testing.T) {
+	_, prov := setupFakeProvider(t)
+
+	c := installCmd{
+		resolver: Resolver{Providers: map[string]*Provider{"fake": prov}},
+	}
+	cfg := Config{
+		Global: GLobal{Mode: "0750", Group: "0"},
+		Binaries: []BinarySpec{
+			{Name: "tool", Provider: ProviderConfig{DSN: ptr("fake://owner/tool")}, Mode: "preserve"},
+			{BinName: "other", Provider: ProviderConfig{DSN: ptr("fake://owner/other")}, Group: "1234"},
+		},
+	}
+	// as read from the lock file
+	binaries := []BinaryData{
+		{Name: "tool", Provider: "fake", Version: "v1.0.0"},
+		{Name: "other", Provider: "fake", Version: "v1.0.0"},
+		{Name: "unknown", Provider: "fake", Version: "v1.0.0"},
+	}
+
+	if err := c.applyFileOwnership(cfg, binaries); err != nil {
+		t.Fatalf("applyFileOwnership() failed: %v", err)
+	}
+	want := [][2]string{{"preserve", "0"}, {"0750", "1234"}, {"0750", "0"}}
+	for i, data := range binaries {
+		if got := [2]string{data.Mode, data.Group}; got != want[i] {
+			t.Errorf("%s: (mode, group) = %v, want %v", data.Name, got, want[i])
+		}
+	}
+
+	cfg.Binaries[0].Mode = "0800"
+	if err := c.applyFileOwnership(cfg, binaries); err == nil {
+		t.Error("applyFileOwnership() with invalid mode succeeded unexpectedly")
+	}
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/cluttrdev/cli"
 	"github.com/goccy/go-yaml"
@@ -35,10 +39,18 @@ type lockCommand struct {
 	rootCmd
 
 	resolver Resolver
+	strict   bool
 }
 
 func (c *lockCommand) RegisterFlags(fs *flag.FlagSet) {
 	c.rootCmd.RegisterFlags(fs)
+
+	fs.IntVar(&c.resolver.Jobs, "jobs", defaultJobs, "The maximum number of binaries to resolve concurrently.")
+	fs.BoolVar(&c.resolver.KeepGoing, "keep-going", false, "Continue if binaries fail to resolve and keep their previous lock entries.")
+	fs.BoolVar(&c.strict, "strict", false, "Exit with an error if any binary failed to resolve, even with --keep-going.")
+	fs.BoolVar(&c.resolver.IgnoreMinAge, "ignore-min-age", false, "Ignore the minimum age of versions, e.g. for urgent security fixes.")
+	fs.BoolVar(&c.resolver.Cache.Refresh, "refresh", false, "Ignore cached version lookups and fetch them again.")
+	fs.DurationVar(&c.resolver.RateLimitWait, "rate-limit-wait", 0, "The maximum time to wait for exceeded API rate limits to reset instead of failing.")
 }
 
 func (c *lockCommand) Exec(ctx context.Context, args []string) (err error) {
@@ -56,31 +68,138 @@ func (c *lockCommand) Exec(ctx context.Context, args []string) (err error) {
 		return err
 	}
 
-	authFile := filepath.Join(xdgDir(xdgConfigHome), "auth.yaml")
-	tokens, err := LoadAuthTokens(authFile)
-	if err != nil {
-		return fmt.Errorf("load auth tokens: %w", err)
+	if err := initResolver(&c.resolver, cfg); err != nil {
+		return err
 	}
+	var skipped skippedReleases
+	c.resolver.OnSkip = skipped.add
 
-	providers, err := InitProviders(append(builtinProviderSpecs, cfg.Providers...), tokens)
-	if err != nil {
-		return fmt.Errorf("init providers: %w", err)
-	}
-	c.resolver.Providers = providers
+	lockfile := replaceFileExt(c.ConfigFile, ".lock")
 
 	spinner, _ := pterm.DefaultSpinner.Start("Resolving binaries")
 	lock, err := c.resolver.Resolve(ctx, cfg.Binaries)
-	if err != nil {
+	var resolveErr *ResolveError
+	if errors.As(err, &resolveErr) {
+		for _, f := range resolveErr.Failures {
+			slog.With("error", f.Err).
+				With(metaerr.GetMetadata(f.Err)...).
+				Error("failed to resolve binary")
+		}
+		spinner.Warning(fmt.Sprintf("Resolved binaries with %d failures", len(resolveErr.Failures)))
+
+		lock, err = c.keepPrevious(lock, lockfile, resolveErr)
+		if err != nil {
+			return err
+		}
+		printFailures(resolveErr, lock)
+	} else if err != nil {
 		slog.With("error", err).
 			With(metaerr.GetMetadata(err)...).
 			Error("failed to resolve binaries")
 		spinner.Fail()
+		skipped.print()
 		return err
+	} else {
+		spinner.Success()
 	}
-	spinner.Success()
+	skipped.print()
 
-	lockfile := replaceFileExt(c.ConfigFile, ".lock")
-	return writeLockFile(lockfile, lock)
+	if err := writeLockFile(lockfile, lock); err != nil {
+		return err
+	}
+
+	if resolveErr != nil && c.strict {
+		return resolveErr
+	}
+	return nil
+}
+
+// keepPrevious adds the entries of the existing lockfile for all binaries
+// that failed to resolve.
+func (c *lockCommand) keepPrevious(lock Lock, lockfile string, resolveErr *ResolveError) (Lock, error) {
+	prev, err := readLockFile(lockfile)
+	if errors.Is(err, os.ErrNotExist) {
+		return lock, nil
+	} else if err != nil {
+		return Lock{}, fmt.Errorf("read lockfile: %w", err)
+	}
+
+	names := make([]string, 0, len(resolveErr.Failures))
+	for _, f := range resolveErr.Failures {
+		names = append(names, f.Name)
+	}
+	return c.resolver.Merge(lock, prev, names)
+}
+
+// skippedReleases collects the releases that are skipped while resolving, to
+// print them once resolving is done.
+type skippedReleases struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (s *skippedReleases) add(name string, release Release, reason error) {
+	entry := fmt.Sprintf("Skipped %s %s: %v", name, release.Tag, reason)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// releases may be checked again, e.g. after prefetching them
+	if slices.Contains(s.entries, entry) {
+		return
+	}
+	s.entries = append(s.entries, entry)
+}
+
+func (s *skippedReleases) print() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		pterm.Warning.Println(entry)
+	}
+	s.entries = nil
+}
+
+// printFailures renders a summary table of the binaries that failed to
+// resolve and the version they are still locked at.
+func printFailures(resolveErr *ResolveError, lock Lock) {
+	data := pterm.TableData{
+		{"Name", "Locked", "Error", "URL", "Status", "Body"},
+	}
+	for _, f := range resolveErr.Failures {
+		locked := "-"
+		for _, b := range lock.Binaries {
+			if b.Name == f.Name {
+				locked = b.Version
+				break
+			}
+		}
+		data = append(data, []string{
+			f.Name,
+			locked,
+			f.Err.Error(),
+			metadataString(f.Err, "url"),
+			metadataString(f.Err, "status"),
+			excerpt(metadataString(f.Err, "body"), 60),
+		})
+	}
+	_ = pterm.DefaultTable.WithHasHeader().WithData(data).Render()
+}
+
+func metadataString(err error, key string) string {
+	v, ok := metaerr.Lookup(err, key)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// excerpt returns the first n characters of s on a single line.
+func excerpt(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "..."
+	}
+	return s
 }
 
 func readLockFile(name string) (Lock, error) {
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/cluttrdev/cli"
+	"github.com/pterm/pterm"
+)
+
+func newPruneCmd() *cli.Command {
+	cfg := pruneCmd{}
+
+	fs := flag.NewFlagSet("prebuilt prune", flag.ExitOnError)
+
+	cfg.RegisterFlags(fs)
+
+	return &cli.Command{
+		Name:       "prune",
+		ShortHelp:  "Remove inactive versions from the store.",
+		ShortUsage: "prebuilt prune [OPTION]... [NAME]...",
+		Flags:      fs,
+		Exec:       cfg.Exec,
+	}
+}
+
+type pruneCmd struct {
+	rootCmd
+
+	// flags
+	dryRun bool
+}
+
+func (c *pruneCmd) RegisterFlags(fs *flag.FlagSet) {
+	c.rootCmd.RegisterFlags(fs)
+
+	fs.BoolVar(&c.dryRun, "dry-run", false, "Only print the versions that would be removed.")
+}
+
+func (c *pruneCmd) Exec(ctx context.Context, args []string) error {
+	if err := c.initLogging(); err != nil {
+		return err
+	}
+
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	// versions linked from any known install directory are in use
+	dirs, err := installDirs()
+	if err != nil {
+		return fmt.Errorf("read install directories: %w", err)
+	}
+	installDir, err := filepath.Abs(expandPath(cfg.Global.InstallDir))
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(dirs, installDir) {
+		dirs = append(dirs, installDir)
+	}
+
+	names := args
+	for _, name := range names {
+		if err := checkStoreName(name); err != nil {
+			return err
+		}
+	}
+	if len(names) == 0 {
+		entries, err := os.ReadDir(storePath())
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read store: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && checkStoreName(entry.Name()) == nil {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	for _, name := range names {
+		active, err := activeVersions(dirs, name)
+		if err != nil {
+			return fmt.Errorf("find active links: %w", err)
+		}
+
+		versions, err := installedVersions(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("list installed versions: %w", err)
+		}
+		for _, version := range versions {
+			if active[version] {
+				continue
+			}
+			if c.dryRun {
+				pterm.Info.Printfln("Would remove %s@%s", name, version)
+				continue
+			}
+			if err := os.RemoveAll(storePath(name, version)); err != nil {
+				return fmt.Errorf("remove %s@%s: %w", name, version, err)
+			}
+			pterm.Success.Printfln("Removed %s@%s", name, version)
+		}
+	}
+
+	return nil
+}
+
+// activeVersions returns the versions of the named binary that are linked
+// from any of the given install directories. Directories that don't exist
+// have no links.
+func activeVersions(dirs []string, name string) (map[string]bool, error) {
+	active := make(map[string]bool)
+	for _, dir := range dirs {
+		links, err := linksInto(dir, storePath(name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, target := range links {
+			rel, err := filepath.Rel(storePath(name), target)
+			if err != nil {
+				return nil, err
+			}
+			version, _, _ := strings.Cut(rel, string(filepath.Separator))
+			active[version] = true
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_pruneCmd_Exec(t *testing.T) {
+	tests := []struct {
+		testName string
+		args     []string
+		dryRun   bool
+		// otherActive is linked from another registered install directory
+		otherActive string
+		// noInstallDir removes the configured install directory
+		noInstallDir bool
+		want         []string
+		wantErr      bool
+	}{
+		{testName: "all binaries", want: []string{"v2.0.0"}},
+		{testName: "named binary", args: []string{"tool"}, want: []string{"v2.0.0"}},
+		{testName: "other binary", args: []string{"other"}, want: []string{"v1.0.0", "v2.0.0", "v3.0.0"}},
+		{testName: "dry run", dryRun: true, want: []string{"v1.0.0", "v2.0.0", "v3.0.0"}},
+		{testName: "other install dir", otherActive: "v1.0.0", want: []string{"v1.0.0", "v2.0.0"}},
+		{testName: "missing install dir", noInstallDir: true, want: nil},
+		{testName: "missing install dir with other", otherActive: "v3.0.0", noInstallDir: true, want: []string{"v3.0.0"}},
+		{testName: "parent name", args: []string{".."}, wantErr: true},
+		{testName: "nested name", args: []string{"tool/v1.0.0"}, wantErr: true},
+		{testName: "store root", args: []string{"."}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			configFile, installDir := setupStore(t, "tool", []string{"v1.0.0", "v2.0.0", "v3.0.0"}, "v2.0.0")
+			if tt.otherActive != "" {
+				otherDir := t.TempDir()
+				dir, _ := storeDir("tool", tt.otherActive)
+				if err := Link(filepath.Join(dir, "tool"), filepath.Join(otherDir, "tool")); err != nil {
+					t.Fatal(err)
+				}
+				if err := registerInstallDir(otherDir); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.noInstallDir {
+				if err := os.RemoveAll(installDir); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := pruneCmd{rootCmd: rootCmd{ConfigFile: configFile}, dryRun: tt.dryRun}
+			err := c.Exec(context.Background(), tt.args)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("Exec() failed: %v", err)
+				}
+			} else if tt.wantErr {
+				t.Fatal("Exec() succeeded unexpectedly")
+			}
+
+			got, err := installedVersions("tool")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if tt.wantErr {
+				want = []string{"v1.0.0", "v2.0.0", "v3.0.0"}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("installed versions = %v, want %v", got, want)
+			}
+			if _, err := os.Stat(storePath()); err != nil {
+				t.Errorf("store: %v", err)
+			}
+		})
+	}
+}
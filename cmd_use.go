@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cluttrdev/cli"
+	"github.com/pterm/pterm"
+)
+
+func newUseCmd() *cli.Command {
+	cfg := useCmd{}
+
+	fs := flag.NewFlagSet("prebuilt use", flag.ExitOnError)
+
+	cfg.RegisterFlags(fs)
+
+	return &cli.Command{
+		Name:       "use",
+		ShortHelp:  "Switch the active version of an installed binary.",
+		ShortUsage: "prebuilt use [OPTION]... NAME@VERSION",
+		Flags:      fs,
+		Exec:       cfg.Exec,
+	}
+}
+
+type useCmd struct {
+	rootCmd
+}
+
+func (c *useCmd) RegisterFlags(fs *flag.FlagSet) {
+	c.rootCmd.RegisterFlags(fs)
+}
+
+func (c *useCmd) Exec(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	name, version, ok := strings.Cut(args[0], "@")
+	if !ok || name == "" || version == "" {
+		return fmt.Errorf("invalid argument: %s", args[0])
+	}
+
+	if err := c.initLogging(); err != nil {
+		return err
+	}
+
+	cfg, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	installDir := expandPath(cfg.Global.InstallDir)
+
+	versionDir, err := storeDir(name, version)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(versionDir); err != nil {
+		versions, _ := installedVersions(name)
+		if len(versions) == 0 {
+			return fmt.Errorf("not installed: %s", name)
+		}
+		return fmt.Errorf("version not installed: %s@%s (installed: %s)", name, version, strings.Join(versions, ", "))
+	}
+
+	links, err := linksInto(installDir, storePath(name))
+	if err != nil {
+		return fmt.Errorf("find active links: %w", err)
+	}
+	if len(links) == 0 {
+		return fmt.Errorf("no active version: %s", name)
+	}
+
+	// determine all new targets before switching any of the links
+	targets := make(map[string]string, len(links))
+	for link, target := range links {
+		rel, err := filepath.Rel(storePath(name), target)
+		if err != nil {
+			return err
+		}
+		// strip the version directory
+		_, rel, _ = strings.Cut(rel, string(filepath.Separator))
+
+		newTarget := filepath.Join(versionDir, rel)
+		if _, err := os.Stat(newTarget); err != nil {
+			return fmt.Errorf("missing executable: %w", err)
+		}
+		targets[link] = newTarget
+	}
+
+	if err := registerInstallDir(installDir); err != nil {
+		return fmt.Errorf("register install directory: %w", err)
+	}
+	for link, target := range targets {
+		if err := Link(target, link); err != nil {
+			return fmt.Errorf("link executable: %w", err)
+		}
+	}
+
+	pterm.Success.Printfln("Using %s@%s", name, version)
+	return nil
+}
+
+// installedVersions returns the versions of the named binary that are
+// present in the store.
+func installedVersions(name string) ([]string, error) {
+	entries, err := os.ReadDir(storePath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		versions = append(versions, entry.Name())
+	}
+	return versions, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupStore creates a store with the given versions of a binary below a
+// temporary PREBUILT_HOME, links the active version into the returned install
+// directory and writes a config file that uses it.
+func setupStore(t *testing.T, name string, versions []string, active string) (configFile string, installDir string) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("PREBUILT_HOME", home)
+	if err := os.MkdirAll(xdgDir(xdgStateHome), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	installDir = filepath.Join(home, "bin")
+	if err := os.MkdirAll(installDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range versions {
+		dir, err := storeDir(name, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(version), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if active != "" {
+		dir, _ := storeDir(name, active)
+		if err := Link(filepath.Join(dir, name), filepath.Join(installDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configFile = filepath.Join(home, "prebuilt.yaml")
+	if err := os.WriteFile(configFile, []byte("global:\n  installDir: "+installDir+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configFile, installDir
+}
+
+func Test_useCmd_Exec(t *testing.T) {
+	tests := []struct {
+		testName string
+		arg      string
+		want     string
+		wantErr  bool
+	}{
+		{testName: "switch", arg: "tool@v1.0.0", want: "v1.0.0"},
+		{testName: "current", arg: "tool@v2.0.0", want: "v2.0.0"},
+		{testName: "not installed version", arg: "tool@v3.0.0", wantErr: true},
+		{testName: "not installed binary", arg: "other@v1.0.0", wantErr: true},
+		{testName: "missing version", arg: "tool", wantErr: true},
+		{testName: "parent name", arg: "..@store", wantErr: true},
+		{testName: "parent version", arg: "tool@..", wantErr: true},
+		{testName: "nested name", arg: "tool/../tool@v1.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			configFile, installDir := setupStore(t, "tool", []string{"v1.0.0", "v2.0.0"}, "v2.0.0")
+
+			c := useCmd{rootCmd: rootCmd{ConfigFile: configFile}}
+			err := c.Exec(context.Background(), []string{tt.arg})
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("Exec() failed: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("Exec() succeeded unexpectedly")
+			}
+
+			got, err := os.ReadFile(filepath.Join(installDir, "tool"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("active version = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_linksInto(t *testing.T) {
+	dir, root := t.TempDir(), t.TempDir()
+	for name, target := range map[string]string{
+		"tool":    filepath.Join(root, "tool", "v1.0.0", "tool"),
+		".tool":   filepath.Join(root, "tool", "v0.9.0", "tool"),
+		"other":   filepath.Join(dir, "other.real"),
+		"outside": filepath.Join(root, "..", "elsewhere"),
+		"root":    root,
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := linksInto(dir, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		filepath.Join(dir, "tool"): filepath.Join(root, "tool", "v1.0.0", "tool"),
+	}
+	if len(got) != len(want) || got[filepath.Join(dir, "tool")] != want[filepath.Join(dir, "tool")] {
+		t.Errorf("linksInto() = %v, want %v", got, want)
+	}
+}
@@ -24,6 +24,12 @@ type Config struct {
 // Global holds configuration settings that apply to all managed binaries.
 type GLobal struct {
 	InstallDir string `yaml:"installDir"`
+	// Mode is the default file mode of installed binaries.
+	Mode string `yaml:"mode"`
+	// Group is the default group of installed binaries.
+	Group string `yaml:"group"`
+	// MinAge is the default minimum age of versions, see VersionSpec.
+	MinAge string `yaml:"minAge"`
 }
 
 // BinarySpec holds the configuration settings for a specific binary.
@@ -33,16 +39,59 @@ type BinarySpec struct {
 	Version     Version        `yaml:"version"`
 	Provider    ProviderConfig `yaml:"provider"`
 	ExtractPath string         `yaml:"extractPath"`
+
+	// Layout determines how the binary is installed, either as a single
+	// file (the default) or as a whole directory tree.
+	Layout string `yaml:"layout"`
+	// StripComponents is the number of leading path components to remove
+	// from archive entries when installing a directory tree.
+	StripComponents int `yaml:"stripComponents"`
+	// Bins lists the executables, relative to the tree root, that are
+	// linked into the install directory.
+	Bins []string `yaml:"bins"`
+
+	// Mode is the file mode of the installed binary, either an octal mode
+	// like `0750` or `preserve` to keep the mode recorded in the archive.
+	// If unset, the global default or else `0777` minus the umask is used.
+	Mode string `yaml:"mode"`
+	// Group is the name or numeric id of the group that owns the installed
+	// binary. If unset, the global default or else the user's group is used.
+	Group string `yaml:"group"`
 }
 
+const (
+	layoutFile = "file"
+	layoutTree = "tree"
+)
+
+const fileModePreserve = "preserve"
+
 type Version struct {
 	String *string
 	Spec   *VersionSpec
 }
 
 type VersionSpec struct {
-	Prefix      string `yaml:"prefix"`
-	Constraints string `yaml:"constraints"`
+	Prefix string `yaml:"prefix"`
+	// Pattern is a regular expression that extracts the semantic version from
+	// published versions like `release-2.3.0` using its first capture group,
+	// or one named `version`. It takes precedence over Prefix.
+	Pattern string `yaml:"pattern"`
+	// Scheme is the versioning scheme used to order versions and check the
+	// constraints: semver (default), calver, numeric or lexical.
+	Scheme string `yaml:"scheme"`
+	// Channel is the least stable release channel to consider: stable
+	// (default), rc, beta, alpha or nightly. IncludePrereleases considers
+	// all of them.
+	Channel            string `yaml:"channel"`
+	IncludePrereleases bool   `yaml:"includePrereleases"`
+	// MinAge is the minimum time since a version was published before it
+	// is used, e.g. `72h` or `7d`. It overrides the global default.
+	MinAge string `yaml:"minAge"`
+	// Exclude lists versions or constraints, like `v3.13.0` or
+	// `>=3.14.0, <3.14.2`, that are never used.
+	Exclude     []string `yaml:"exclude"`
+	Constraints string   `yaml:"constraints"`
 }
 
 type ProviderConfig struct {
@@ -51,11 +100,46 @@ type ProviderConfig struct {
 }
 
 type ProviderSpec struct {
-	Name             string `yaml:"name"`
+	Name string `yaml:"name"`
+	// Type selects the backend that lists versions and locates assets.
+	// The default type uses the URL templates below.
+	Type string `yaml:"type"`
+	// BaseURL is the root URL of the provider's instance, e.g. for
+	// self-hosted forges. It is available in templates as `.Provider.BaseURL`.
+	BaseURL          string `yaml:"baseUrl"`
 	VersionsURL      string `yaml:"versionsUrl"`
 	VersionsJSONPath string `yaml:"versionsJsonPath"`
-	DownloadURL      string `yaml:"downloadUrl"`
-	AuthToken        string `yaml:"authToken"`
+	// VersionsFormat is the format of the versions response: json (default),
+	// yaml, text, xml or html. VersionsSelector selects the versions in xml
+	// and html responses and VersionsRegex optionally extracts them from the
+	// selected values.
+	VersionsFormat   string `yaml:"versionsFormat"`
+	VersionsSelector string `yaml:"versionsSelector"`
+	VersionsRegex    string `yaml:"versionsRegex"`
+	// VersionsOrder is the order of the versions endpoint's listing:
+	// newest-first (default), or unsorted to read all pages before picking
+	// the best match.
+	VersionsOrder string `yaml:"versionsOrder"`
+	// GraphQLURL is the GitHub GraphQL endpoint that is used to query the
+	// releases of many repositories at once if an auth token is configured.
+	GraphQLURL string `yaml:"graphqlUrl"`
+	// TagsURL and TagsJSONPath list the tags of a repository for DSNs with
+	// `source=tags`, GitURL is the repository's URL for DSNs with
+	// `source=git`, which lists the tags with the git smart HTTP protocol.
+	TagsURL      string `yaml:"tagsUrl"`
+	TagsJSONPath string `yaml:"tagsJsonPath"`
+	GitURL       string `yaml:"gitUrl"`
+	// ReleaseFields describe the release objects if VersionsJSONPath selects
+	// those instead of the versions.
+	ReleaseFields ReleaseFields `yaml:"releaseFields"`
+	// VersionPattern is the default VersionSpec pattern for the provider's
+	// binaries.
+	VersionPattern string `yaml:"versionPattern"`
+	DownloadURL    string `yaml:"downloadUrl"`
+	AuthToken      string `yaml:"authToken"`
+	// AuthHosts are the hosts of OCI token services besides the registries
+	// themselves that the auth token is sent to, e.g. `auth.docker.io`.
+	AuthHosts []string `yaml:"authHosts"`
 
 	Extends string `yaml:"extends"`
 }
@@ -119,31 +203,68 @@ func LoadConfigFile(name string, cfg *Config) error {
 	return LoadConfig(file, cfg)
 }
 
+// AuthTokens holds the authentication tokens of providers by name and of
+// provider instances by host.
+type AuthTokens struct {
+	Providers map[string]string
+	Instances map[string]string
+}
+
 // LoadAuthTokens loads authentication tokens from a file.
-func LoadAuthTokens(name string) (map[string]string, error) {
+func LoadAuthTokens(name string) (AuthTokens, error) {
 	file, err := os.Open(name)
 	if os.IsNotExist(err) { // this is fine
-		return nil, nil
+		return AuthTokens{}, nil
 	} else if err != nil {
-		return nil, fmt.Errorf("open file: %w", err)
+		return AuthTokens{}, fmt.Errorf("open file: %w", err)
 	}
+	defer func() {
+		_ = file.Close()
+	}()
 
+	type tokenData struct {
+		Token string `yaml:"token"`
+	}
 	auth := struct {
-		Providers map[string]struct {
-			Token string `yaml:"token"`
-		} `yaml:"providers"`
+		Providers map[string]tokenData `yaml:"providers"`
+		Instances map[string]tokenData `yaml:"instances"`
 	}{}
 	if err := yaml.NewDecoder(file).Decode(&auth); err != nil {
-		return nil, fmt.Errorf("decode file: %w", err)
+		return AuthTokens{}, fmt.Errorf("decode file: %w", err)
 	}
 
-	var tokens = make(map[string]string)
+	tokens := AuthTokens{
+		Providers: make(map[string]string, len(auth.Providers)),
+		Instances: make(map[string]string, len(auth.Instances)),
+	}
 	for name, data := range auth.Providers {
-		tokens[name] = data.Token
+		tokens.Providers[name] = data.Token
+	}
+	for host, data := range auth.Instances {
+		tokens.Instances[host] = data.Token
 	}
 	return tokens, nil
 }
 
+// LoadDenylist loads the denied versions from a file.
+func LoadDenylist(name string) (Denylist, error) {
+	file, err := os.Open(name)
+	if os.IsNotExist(err) { // this is fine
+		return Denylist{}, nil
+	} else if err != nil {
+		return Denylist{}, fmt.Errorf("open file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var denylist Denylist
+	if err := yaml.NewDecoder(file).Decode(&denylist); err != nil && err != io.EOF {
+		return Denylist{}, fmt.Errorf("decode file: %w", err)
+	}
+	return denylist, nil
+}
+
 func getBinName(bin BinarySpec, provider ProviderSpec) string {
 	switch {
 	case bin.BinName != "":
@@ -178,6 +299,8 @@ func renderTemplate(tpl string, data map[string]any) (string, error) {
 	return w.String(), nil
 }
 
+var jsonpathEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `"`, `\"`)
+
 func initFuncMap(t *template.Template) {
 	funcMap := make(template.FuncMap)
 
@@ -185,6 +308,14 @@ func initFuncMap(t *template.Template) {
 		return strings.TrimPrefix(s, prefix)
 	}
 
+	// jsonpathescape escapes a value for use in a quoted JSONPath string
+	funcMap["jsonpathescape"] = func(s string) string {
+		return jsonpathEscaper.Replace(s)
+	}
+
+	// pathescape escapes a value for use as a single URL path segment
+	funcMap["pathescape"] = _url.PathEscape
+
 	funcMap["tpl"] = func(tpl string, vals map[string]any) (string, error) {
 		tt, err := t.Clone()
 		if err != nil {
@@ -24,11 +24,14 @@ func TestLoadConfig(t *testing.T) {
 			r: bytes.NewReader([]byte(`
 global:
   installDir: "/usr/local/bin"
+  mode: "0750"
+  group: tools
 binaries:
   - name: prebuilt
     version: latest
     provider: github://cluttrdev/prebuilt?asset=prebuilt_{{ .Version }}_linux-amd64.tar.gz
     extractPath: prebuilt
+    mode: preserve
   - name: jq
     version:
       constraints: jq-1.7.1
@@ -40,10 +43,20 @@ binaries:
       versionsUrl: https://api.github.com/repos/helm/helm/releases
       versionsJsonPath: $[*].tag_name
       downloadUrl: https://get.helm.sh/helm-{{ .Version }}-linux-amd64.tar.gz
+  - name: node
+    version: v22.16.0
+    provider: https://nodejs.org/dist/{{ .Version }}/node-{{ .Version }}-linux-x64.tar.gz
+    layout: tree
+    stripComponents: 1
+    bins:
+      - bin/node
+      - bin/npm
 `)),
 			wantCfg: Config{
 				Global: GLobal{
 					InstallDir: "/usr/local/bin",
+					Mode:       "0750",
+					Group:      "tools",
 				},
 				Binaries: []BinarySpec{
 					{
@@ -53,6 +66,7 @@ binaries:
 							DSN: ptr("github://cluttrdev/prebuilt?asset=prebuilt_{{ .Version }}_linux-amd64.tar.gz"),
 						},
 						ExtractPath: "prebuilt",
+						Mode:        "preserve",
 					},
 					{
 						Name:    "jq",
@@ -72,6 +86,16 @@ binaries:
 							},
 						},
 					},
+					{
+						Name:    "node",
+						Version: Version{String: ptr("v22.16.0")},
+						Provider: ProviderConfig{
+							DSN: ptr("https://nodejs.org/dist/{{ .Version }}/node-{{ .Version }}-linux-x64.tar.gz"),
+						},
+						Layout:          "tree",
+						StripComponents: 1,
+						Bins:            []string{"bin/node", "bin/npm"},
+					},
 				},
 			},
 			wantErr: false,
@@ -142,6 +166,18 @@ func Test_renderTemplate(t *testing.T) {
 			data: map[string]any{"Version": "v0.1.0"},
 			want: "https://github.com/cluttrdev/prebuilt/releases/download/v0.1.0/prebuilt_v0.1.0_linux-amd64.tar.gz",
 		},
+		{
+			name: "jsonpathescape",
+			tmpl: `$[?(@.name=='{{ .Name | jsonpathescape }}')]`,
+			data: map[string]any{"Name": `it's a\b`},
+			want: `$[?(@.name=='it\'s a\\b')]`,
+		},
+		{
+			name: "pathescape",
+			tmpl: `packages/generic/{{ .Name | pathescape }}/{{ .Version | pathescape }}`,
+			data: map[string]any{"Name": "my tool", "Version": "1.0+build/1"},
+			want: `packages/generic/my%20tool/1.0+build%2F1`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -2,22 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	_url "net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"go.cluttr.dev/prebuilt/internal/metaerr"
 )
 
+var errChecksumMismatch = errors.New("checksum mismatch")
+
 // Download retrieves a binary asset from the given url and saves it in the
-// given directory.
+// given directory, using the file name from the url unless `filename` is set.
 // It returns the local absolut path to the downloaded file.
-func Download(ctx context.Context, client *http.Client, url string, dir string) (string, error) {
-	u, _ := _url.Parse(url)
-	filename := filepath.Base(u.Path)
+func Download(ctx context.Context, client *http.Client, url string, dir string, filename string) (string, error) {
+	if filename == "" {
+		u, _ := _url.Parse(url)
+		filename = filepath.Base(u.Path)
+	}
+	if !filepath.IsLocal(filename) || filepath.Base(filename) != filename {
+		return "", fmt.Errorf("%w: %s", errInsecurePath, filename)
+	}
 
 	file, err := os.Create(filepath.Join(dir, filename))
 	if err != nil {
@@ -27,7 +40,11 @@ func Download(ctx context.Context, client *http.Client, url string, dir string)
 		_ = file.Close()
 	}()
 
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -38,6 +55,7 @@ func Download(ctx context.Context, client *http.Client, url string, dir string)
 		body, _ := io.ReadAll(resp.Body)
 		return "", metaerr.WithMetadata(
 			fmt.Errorf("%d - %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+			"status", resp.StatusCode,
 			"body", string(body),
 		)
 	}
@@ -49,3 +67,39 @@ func Download(ctx context.Context, client *http.Client, url string, dir string)
 
 	return file.Name(), err
 }
+
+// VerifyChecksum checks that the file matches the given checksum of the form
+// `algorithm:hex`, e.g. `sha256:e3b0c442...`.
+func VerifyChecksum(name string, checksum string) error {
+	algorithm, want, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return fmt.Errorf("invalid checksum: %s", checksum)
+	}
+
+	var hash crypto.Hash
+	switch algorithm {
+	case "sha256":
+		hash = crypto.SHA256
+	case "sha512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	h := hash.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+		return metaerr.WithMetadata(errChecksumMismatch, "want", checksum, "got", algorithm+":"+got)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		testName string
+		checksum string
+		wantErr  error
+	}{
+		{
+			testName: "sha256",
+			checksum: "sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		},
+		{
+			testName: "sha512",
+			checksum: "sha512:e7c22b994c59d9cf2b48e549b1e24666636045930d3da7c1acb299d1c3b7f931f94aae41edda2c2b207a36e10f8bcb8d45223e54878f5b316e7ce3b6bc019629",
+		},
+		{
+			testName: "uppercase",
+			checksum: "sha256:5891B5B522D5DF086D0FF0B110FBD9D21BB4FC7163AF34D08286A2E846F6BE03",
+		},
+		{
+			testName: "mismatch",
+			checksum: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			wantErr:  errChecksumMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := VerifyChecksum(name, tt.checksum)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyChecksum() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := VerifyChecksum(name, "md5:b1946ac92492d2347c6235b4d2611184"); err == nil {
+		t.Error("VerifyChecksum() accepted unsupported algorithm")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Denylist lists versions that must not be used, e.g. broken or yanked
+// upstream releases. It is read from `denylist.yaml` in the config directory
+// and applies to all projects.
+type Denylist struct {
+	Entries []DenylistEntry `yaml:"deny"`
+}
+
+// DenylistEntry denies versions of the binaries that match the provider
+// and/or name.
+type DenylistEntry struct {
+	// Provider is the provider and project of the binaries, e.g.
+	// `github://helm/helm`.
+	Provider string `yaml:"provider"`
+	// Name is the name of the binaries.
+	Name string `yaml:"name"`
+	// Versions are the denied versions or constraints, see VersionSpec.Exclude.
+	Versions []string `yaml:"versions"`
+	Reason   string   `yaml:"reason"`
+}
+
+// matches reports whether the entry applies to the binary.
+func (e DenylistEntry) matches(name string, data ProviderData) bool {
+	if e.Provider == "" && e.Name == "" {
+		return false
+	}
+	if e.Name != "" && e.Name != name {
+		return false
+	}
+	if e.Provider != "" {
+		provider, _, _ := strings.Cut(e.Provider, "?")
+		if strings.TrimSuffix(provider, "/") != data.Scheme+"://"+data.Project() {
+			return false
+		}
+	}
+	return true
+}
+
+// exclusion rejects versions that match a version or constraint.
+type exclusion struct {
+	version string
+	check   versionCheck
+	reason  string
+}
+
+// versionExclusions reject releases that are excluded by the binary's
+// VersionSpec or denied by the denylist.
+type versionExclusions struct {
+	scheme     versionScheme
+	exclusions []exclusion
+}
+
+func newVersionExclusions(spec VersionSpec, denylist Denylist, name string, data ProviderData) (*versionExclusions, error) {
+	scheme, err := newVersionScheme(spec.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	e := &versionExclusions{scheme: scheme}
+
+	add := func(version string, reason string) error {
+		check, err := scheme.parseConstraints(strings.TrimPrefix(version, spec.Prefix), true)
+		if err != nil {
+			return fmt.Errorf("parse excluded version %q: %w", version, err)
+		}
+		e.exclusions = append(e.exclusions, exclusion{version: version, check: check, reason: reason})
+		return nil
+	}
+	for _, version := range spec.Exclude {
+		if err := add(version, "excluded"); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range denylist.Entries {
+		if !entry.matches(name, data) {
+			continue
+		}
+		reason := "denied"
+		if entry.Reason != "" {
+			reason += ": " + entry.Reason
+		}
+		for _, version := range entry.Versions {
+			if err := add(version, reason); err != nil {
+				return nil, fmt.Errorf("denylist: %w", err)
+			}
+		}
+	}
+	return e, nil
+}
+
+// check returns an error with the reason if the release is excluded.
+func (e *versionExclusions) check(release Release) error {
+	if len(e.exclusions) == 0 {
+		return nil
+	}
+	v, err := e.scheme.parse(release.Version)
+	for _, x := range e.exclusions {
+		if x.version == release.Tag || (err == nil && x.check(v)) {
+			return fmt.Errorf("%s (%s)", x.reason, x.version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadDenylist(t *testing.T) {
+	got, err := LoadDenylist("testdata/denylist.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Denylist{Entries: []DenylistEntry{
+		{Provider: "github://helm/helm", Versions: []string{"v3.16.0"}, Reason: "broken OCI registry login"},
+		{Name: "kustomize", Versions: []string{">=5.5.0, <5.5.2"}},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LoadDenylist() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = LoadDenylist("testdata/missing.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entries) != 0 {
+		t.Errorf("LoadDenylist() = %v, want empty denylist", got)
+	}
+}
+
+func TestDenylistEntry_matches(t *testing.T) {
+	data := ProviderData{Scheme: "github", Host: "helm", Path: "helm"}
+	tests := []struct {
+		testName string
+		entry    DenylistEntry
+		want     bool
+	}{
+		{testName: "provider", entry: DenylistEntry{Provider: "github://helm/helm"}, want: true},
+		{testName: "provider with query", entry: DenylistEntry{Provider: "github://helm/helm/?asset=helm"}, want: true},
+		{testName: "other provider", entry: DenylistEntry{Provider: "gitlab://helm/helm"}, want: false},
+		{testName: "name", entry: DenylistEntry{Name: "helm"}, want: true},
+		{testName: "provider and other name", entry: DenylistEntry{Provider: "github://helm/helm", Name: "kubectl"}, want: false},
+		{testName: "empty", entry: DenylistEntry{}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := tt.entry.matches("helm", data); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionExclusions(t *testing.T) {
+	data := ProviderData{Scheme: "github", Host: "helm", Path: "helm"}
+	denylist := Denylist{Entries: []DenylistEntry{
+		{Provider: "github://helm/helm", Versions: []string{"3.16.0"}, Reason: "broken OCI login"},
+		{Name: "kubectl", Versions: []string{"*"}},
+	}}
+	e, err := newVersionExclusions(VersionSpec{Prefix: "v", Exclude: []string{"v3.15.1", ">=3.14.0, <3.14.3"}}, denylist, "helm", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		release Release
+		want    string
+	}{
+		{release: Release{Tag: "v3.15.1", Version: "3.15.1"}, want: "excluded (v3.15.1)"},
+		{release: Release{Tag: "v3.14.2", Version: "3.14.2"}, want: "excluded (>=3.14.0, <3.14.3)"},
+		{release: Release{Tag: "v3.16.0", Version: "3.16.0"}, want: "denied: broken OCI login (3.16.0)"},
+		{release: Release{Tag: "v3.16.1", Version: "3.16.1"}},
+		{release: Release{Tag: "v3.14.3", Version: "3.14.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.release.Tag, func(t *testing.T) {
+			var got string
+			if err := e.check(tt.release); err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newVersionExclusions(VersionSpec{Exclude: []string{"~>"}}, Denylist{}, "helm", data); err == nil {
+		t.Error("newVersionExclusions() succeeded unexpectedly with an invalid constraint")
+	}
+}
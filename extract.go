@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -11,50 +12,54 @@ import (
 	"strings"
 )
 
+// extractSizeLimit bounds the total number of bytes that are written when
+// extracting from an archive, to protect against decompression bombs.
+var extractSizeLimit int64 = 8 << 30 // 8 GiB
+
+var (
+	errInsecurePath     = errors.New("insecure path")
+	errInsecureLink     = errors.New("insecure link target")
+	errUnsupportedEntry = errors.New("unsupported entry type")
+	errSizeLimit        = errors.New("extraction size limit exceeded")
+)
+
 // Extract opens the given archive and retrieves the file specified by path.
-// It returns the local absolute path to the extracted file.
-func Extract(archive string, path string) (string, error) {
+// It returns the local absolute path to the extracted file and the
+// permissions recorded for it in the archive.
+func Extract(archive string, path string) (string, os.FileMode, error) {
 	in, err := os.Open(archive)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	defer func() {
 		_ = in.Close()
 	}()
 
-	reader, err := newArchiveFileReader(in, path)
+	x := newExtractor(filepath.Dir(archive))
+	dst, err := x.path(path)
 	if err != nil {
-		return "", err
-	}
-
-	dst := filepath.Join(filepath.Dir(archive), path)
-	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	out, err := os.Create(dst)
+	reader, perm, err := newArchiveFileReader(in, path)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	defer func() {
-		_ = out.Close()
-	}()
 
-	_, err = io.Copy(out, reader)
-	if err != nil {
-		return "", err
+	if err := x.writeFile(dst, perm, reader); err != nil {
+		return "", 0, err
 	}
 
-	return dst, nil
+	return dst, perm, nil
 }
 
-func newArchiveFileReader(archive *os.File, filename string) (io.Reader, error) {
+func newArchiveFileReader(archive *os.File, filename string) (io.Reader, os.FileMode, error) {
 	name := archive.Name()
 	switch {
 	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
 		gzReader, err := gzip.NewReader(archive)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		tarReader := tar.NewReader(gzReader)
 		for {
@@ -63,21 +68,376 @@ func newArchiveFileReader(archive *os.File, filename string) (io.Reader, error)
 				break
 			}
 			if header.Name == filename {
-				return tarReader, nil
+				if header.Typeflag != tar.TypeReg {
+					return nil, 0, fmt.Errorf("not a regular file: %v", filename)
+				}
+				return tarReader, header.FileInfo().Mode().Perm(), nil
 			}
 		}
-		return nil, fmt.Errorf("file not found: %v", filename)
+		return nil, 0, fmt.Errorf("file not found: %v", filename)
 	case strings.HasSuffix(name, ".zip"):
 		stat, err := archive.Stat()
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		zipReader, err := zip.NewReader(archive, stat.Size())
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
-		return zipReader.Open(filename)
+		file, err := zipReader.Open(filename)
+		if err != nil {
+			return nil, 0, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return nil, 0, err
+		} else if !info.Mode().IsRegular() {
+			return nil, 0, fmt.Errorf("not a regular file: %v", filename)
+		}
+		return file, info.Mode().Perm(), nil
 	}
 
-	return nil, fmt.Errorf("unsupported archive")
+	return nil, 0, fmt.Errorf("unsupported archive")
+}
+
+// ExtractAll unpacks all entries of the given archive into the directory dst.
+// The first `strip` leading path components are removed from every entry
+// name, entries that have no components left are skipped.
+//
+// Every entry is validated before it is written. Entries with absolute or
+// parent-relative paths, links pointing outside of dst, device files and
+// archives that exceed the extraction size limit are rejected.
+//
+// File permissions are taken from the archive and are subject to the umask,
+// unless `preserveMode` is set.
+func ExtractAll(archive string, dst string, strip int, preserveMode bool) error {
+	in, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	x := newExtractor(dst)
+	x.preserveMode = preserveMode
+
+	name := in.Name()
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gzReader, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		tarReader := tar.NewReader(gzReader)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			path, ok, err := archivePath(header.Name, strip)
+			if err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			if err := x.extractTarEntry(header, path, strip, tarReader); err != nil {
+				return fmt.Errorf("extract %s: %w", header.Name, err)
+			}
+		}
+	case strings.HasSuffix(name, ".zip"):
+		stat, err := in.Stat()
+		if err != nil {
+			return err
+		}
+		zipReader, err := zip.NewReader(in, stat.Size())
+		if err != nil {
+			return err
+		}
+		for _, file := range zipReader.File {
+			path, ok, err := archivePath(file.Name, strip)
+			if err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			if err := x.extractZipFile(file, path); err != nil {
+				return fmt.Errorf("extract %s: %w", file.Name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported archive")
+	}
+
+	return x.verifyLinks()
+}
+
+// extractor writes archive entries below a root directory and keeps track
+// of the remaining extraction budget.
+type extractor struct {
+	root      string
+	remaining int64
+	links     []string
+
+	// preserveMode disables the umask for extracted files
+	preserveMode bool
+}
+
+func newExtractor(root string) *extractor {
+	return &extractor{
+		root:      root,
+		remaining: extractSizeLimit,
+	}
+}
+
+func (x *extractor) extractTarEntry(header *tar.Header, name string, strip int, r io.Reader) error {
+	dst, err := x.path(name)
+	if err != nil {
+		return err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return x.mkdir(dst)
+	case tar.TypeReg:
+		return x.writeFile(dst, header.FileInfo().Mode().Perm(), r)
+	case tar.TypeSymlink:
+		return x.symlink(dst, header.Linkname)
+	case tar.TypeLink:
+		// hard link targets are archive paths as well
+		target, ok, err := archivePath(header.Linkname, strip)
+		if err != nil || !ok {
+			return fmt.Errorf("%w: %s", errInsecureLink, header.Linkname)
+		}
+		return x.hardlink(dst, target)
+	case tar.TypeXGlobalHeader:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q", errUnsupportedEntry, header.Typeflag)
+}
+
+func (x *extractor) extractZipFile(file *zip.File, name string) error {
+	dst, err := x.path(name)
+	if err != nil {
+		return err
+	}
+
+	mode := file.Mode()
+	switch {
+	case mode.IsDir():
+		return x.mkdir(dst)
+	case mode.IsRegular(), mode&os.ModeSymlink != 0:
+	default:
+		return fmt.Errorf("%w: %v", errUnsupportedEntry, mode.Type())
+	}
+
+	if file.UncompressedSize64 > uint64(x.remaining) {
+		return errSizeLimit
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	if mode&os.ModeSymlink != 0 {
+		// zip archives store the symlink target as the entry's content
+		target, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		return x.symlink(dst, string(target))
+	}
+
+	return x.writeFile(dst, mode.Perm(), rc)
+}
+
+// path validates the archive entry name and returns its destination path.
+// Names must be local to the root and may not traverse any symbolic links
+// that were created by previous entries.
+func (x *extractor) path(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %s", errInsecurePath, name)
+	}
+
+	dir := x.root
+	for _, part := range strings.Split(filepath.Dir(name), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %s traverses symbolic link", errInsecurePath, name)
+		}
+	}
+
+	return filepath.Join(x.root, name), nil
+}
+
+func (x *extractor) mkdir(dst string) error {
+	return os.MkdirAll(dst, os.ModePerm)
+}
+
+func (x *extractor) writeFile(dst string, perm os.FileMode, r io.Reader) error {
+	if err := x.prepare(dst); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = out.Close()
+	}()
+
+	if x.preserveMode {
+		if err := out.Chmod(perm); err != nil {
+			return err
+		}
+	}
+
+	n, err := io.Copy(out, io.LimitReader(r, x.remaining+1))
+	if err != nil {
+		return err
+	}
+	if n > x.remaining {
+		return errSizeLimit
+	}
+	x.remaining -= n
+
+	return out.Close()
+}
+
+func (x *extractor) symlink(dst string, linkname string) error {
+	target := filepath.FromSlash(linkname)
+	if filepath.IsAbs(target) || !filepath.IsLocal(filepath.Join(x.rel(filepath.Dir(dst)), target)) {
+		return fmt.Errorf("%w: %s", errInsecureLink, linkname)
+	}
+
+	if err := x.prepare(dst); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		return err
+	}
+	x.links = append(x.links, dst)
+	return nil
+}
+
+func (x *extractor) hardlink(dst string, name string) error {
+	target, err := x.path(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInsecureLink, name)
+	}
+	if info, err := os.Lstat(target); err != nil {
+		return err
+	} else if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s", errInsecureLink, name)
+	}
+
+	if err := x.prepare(dst); err != nil {
+		return err
+	}
+	return os.Link(target, dst)
+}
+
+// prepare creates the parent directory of dst and removes any non-directory
+// file that already exists at dst, so that it is never written through.
+func (x *extractor) prepare(dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(dst); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%w: %s is a directory", errInsecurePath, x.rel(dst))
+		}
+		return os.Remove(dst)
+	}
+	return nil
+}
+
+// verifyLinks ensures that no extracted symbolic link resolves to a location
+// outside of the root, e.g. via a chain of links.
+func (x *extractor) verifyLinks() error {
+	if len(x.links) == 0 {
+		return nil
+	}
+
+	root, err := filepath.EvalSymlinks(x.root)
+	if err != nil {
+		return err
+	}
+	for _, link := range x.links {
+		target, err := filepath.EvalSymlinks(link)
+		if os.IsNotExist(err) { // dangling, but lexically local
+			continue
+		} else if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, target)
+		if err != nil || !filepath.IsLocal(rel) && rel != "." {
+			return fmt.Errorf("%w: %s", errInsecureLink, x.rel(link))
+		}
+	}
+	return nil
+}
+
+// rel returns the path relative to the extraction root.
+func (x *extractor) rel(path string) string {
+	rel, err := filepath.Rel(x.root, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// archivePath validates the slash separated archive entry name and strips
+// its first n components.
+// Absolute names and names containing parent directory references are
+// rejected, regardless of whether they would be stripped.
+func archivePath(name string, n int) (string, bool, error) {
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return "", false, fmt.Errorf("%w: %s", errInsecurePath, name)
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", false, fmt.Errorf("%w: %s", errInsecurePath, name)
+		}
+	}
+	path, ok := stripComponents(name, n)
+	return path, ok, nil
+}
+
+// stripComponents removes the first n components from the slash separated
+// path. It returns false if no components remain.
+func stripComponents(path string, n int) (string, bool) {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) <= n {
+		return "", false
+	}
+	return strings.Join(parts[n:], "/"), true
 }
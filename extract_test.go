@@ -0,0 +1,359 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	mode     int64
+	body     string
+}
+
+func writeTarGz(t *testing.T, dir string, entries []archiveEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     mode,
+			Size:     int64(len(e.body)),
+		}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "archive.tar.gz")
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func writeZip(t *testing.T, dir string, entries []archiveEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{
+			Name:   e.name,
+			Method: zip.Deflate,
+		}
+		switch e.typeflag {
+		case tar.TypeSymlink:
+			header.SetMode(os.ModeSymlink | 0777)
+			e.body = e.linkname
+		default:
+			header.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "archive.zip")
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestExtractAll(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeTarGz(t, dir, []archiveEntry{
+		{name: "./jdk/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "./jdk/bin/java", typeflag: tar.TypeReg, mode: 0755, body: "java"},
+		{name: "./jdk/lib/libjvm.so", typeflag: tar.TypeReg, body: "jvm"},
+		{name: "./jdk/bin/jvm", typeflag: tar.TypeSymlink, linkname: "../lib/libjvm.so"},
+		{name: "./jdk/bin/java2", typeflag: tar.TypeLink, linkname: "./jdk/bin/java"},
+	})
+
+	dst := filepath.Join(dir, "out")
+	if err := ExtractAll(archive, dst, 1, false); err != nil {
+		t.Fatalf("ExtractAll() failed: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"bin/java":  "java",
+		"bin/java2": "java",
+		"bin/jvm":   "jvm",
+	} {
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("read %s: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dst, "bin/java")); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm()&0100 == 0 {
+		t.Errorf("bin/java is not executable: %v", info.Mode())
+	}
+}
+
+func TestExtractAllMalicious(t *testing.T) {
+	tests := []struct {
+		testName string
+		entries  []archiveEntry
+		zip      bool
+		limit    int64
+		wantErr  error
+	}{
+		{
+			testName: "parent path",
+			entries:  []archiveEntry{{name: "../evil", typeflag: tar.TypeReg, body: "x"}},
+			wantErr:  errInsecurePath,
+		},
+		{
+			testName: "nested parent path",
+			entries:  []archiveEntry{{name: "a/../../evil", typeflag: tar.TypeReg, body: "x"}},
+			wantErr:  errInsecurePath,
+		},
+		{
+			testName: "absolute path",
+			entries:  []archiveEntry{{name: "/tmp/evil", typeflag: tar.TypeReg, body: "x"}},
+			wantErr:  errInsecurePath,
+		},
+		{
+			testName: "absolute symlink",
+			entries:  []archiveEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}},
+			wantErr:  errInsecureLink,
+		},
+		{
+			testName: "escaping symlink",
+			entries:  []archiveEntry{{name: "a/link", typeflag: tar.TypeSymlink, linkname: "../../evil"}},
+			wantErr:  errInsecureLink,
+		},
+		{
+			testName: "write through symlink",
+			entries: []archiveEntry{
+				{name: "sub/", typeflag: tar.TypeDir},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "sub"},
+				{name: "link/evil", typeflag: tar.TypeReg, body: "x"},
+			},
+			wantErr: errInsecurePath,
+		},
+		{
+			testName: "symlink chain",
+			entries: []archiveEntry{
+				{name: "a/b/y", typeflag: tar.TypeSymlink, linkname: "../.."},
+				{name: "a/b/c/x", typeflag: tar.TypeSymlink, linkname: "../y/.."},
+			},
+			wantErr: errInsecureLink,
+		},
+		{
+			testName: "overwrite symlink",
+			entries: []archiveEntry{
+				{name: "sub/file", typeflag: tar.TypeReg, body: "x"},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "sub/file"},
+				{name: "link", typeflag: tar.TypeReg, body: "y"},
+			},
+		},
+		{
+			testName: "hard link outside",
+			entries:  []archiveEntry{{name: "link", typeflag: tar.TypeLink, linkname: "../../etc/passwd"}},
+			wantErr:  errInsecureLink,
+		},
+		{
+			testName: "character device",
+			entries:  []archiveEntry{{name: "dev", typeflag: tar.TypeChar}},
+			wantErr:  errUnsupportedEntry,
+		},
+		{
+			testName: "fifo",
+			entries:  []archiveEntry{{name: "fifo", typeflag: tar.TypeFifo}},
+			wantErr:  errUnsupportedEntry,
+		},
+		{
+			testName: "size limit",
+			entries: []archiveEntry{
+				{name: "a", typeflag: tar.TypeReg, body: strings.Repeat("0", 512)},
+				{name: "b", typeflag: tar.TypeReg, body: strings.Repeat("0", 512)},
+			},
+			limit:   1000,
+			wantErr: errSizeLimit,
+		},
+		{
+			testName: "zip parent path",
+			entries:  []archiveEntry{{name: "../evil", typeflag: tar.TypeReg, body: "x"}},
+			zip:      true,
+			wantErr:  errInsecurePath,
+		},
+		{
+			testName: "zip backslash parent path",
+			entries:  []archiveEntry{{name: `..\evil`, typeflag: tar.TypeReg, body: "x"}},
+			zip:      true,
+			wantErr:  errInsecurePath,
+		},
+		{
+			testName: "zip escaping symlink",
+			entries:  []archiveEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "../evil"}},
+			zip:      true,
+			wantErr:  errInsecureLink,
+		},
+		{
+			testName: "zip bomb",
+			entries:  []archiveEntry{{name: "bomb", typeflag: tar.TypeReg, body: strings.Repeat("0", 1<<20)}},
+			zip:      true,
+			limit:    1 << 10,
+			wantErr:  errSizeLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if tt.limit > 0 {
+				limit := extractSizeLimit
+				extractSizeLimit = tt.limit
+				t.Cleanup(func() { extractSizeLimit = limit })
+			}
+
+			// nest the destination, so escapes stay within the test's directory
+			dir := t.TempDir()
+			archiveDir := filepath.Join(dir, "archive")
+			dst := filepath.Join(dir, "a", "b", "out")
+			if err := os.MkdirAll(archiveDir, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			var archive string
+			if tt.zip {
+				archive = writeZip(t, archiveDir, tt.entries)
+			} else {
+				archive = writeTarGz(t, archiveDir, tt.entries)
+			}
+
+			err := ExtractAll(archive, dst, 0, false)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("ExtractAll() failed: %v", err)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExtractAll() error = %v, want %v", err, tt.wantErr)
+			}
+
+			// nothing may have been written outside of dst
+			_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if path == dir || path == archiveDir || path == archive || strings.HasPrefix(path, dst) {
+					return nil
+				}
+				if !info.IsDir() {
+					t.Errorf("unexpected file outside of destination: %s", path)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeTarGz(t, dir, []archiveEntry{
+		{name: "prebuilt", typeflag: tar.TypeReg, mode: 0755, body: "prebuilt"},
+		{name: "../evil", typeflag: tar.TypeReg, body: "x"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"},
+	})
+
+	tests := []struct {
+		testName string
+		path     string
+		want     string
+		wantErr  bool
+	}{
+		{testName: "regular file", path: "prebuilt", want: "prebuilt"},
+		{testName: "parent path", path: "../evil", wantErr: true},
+		{testName: "symlink", path: "link", wantErr: true},
+		{testName: "missing", path: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, _, gotErr := Extract(archive, tt.path)
+			if gotErr != nil {
+				if !tt.wantErr {
+					t.Errorf("Extract() failed: %v", gotErr)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("Extract() succeeded unexpectedly")
+			}
+			data, err := os.ReadFile(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Extract() content = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func Test_stripComponents(t *testing.T) {
+	tests := []struct {
+		path   string
+		n      int
+		want   string
+		wantOK bool
+	}{
+		{path: "go/bin/go", n: 0, want: "go/bin/go", wantOK: true},
+		{path: "go/bin/go", n: 1, want: "bin/go", wantOK: true},
+		{path: "./go/bin/go", n: 1, want: "bin/go", wantOK: true},
+		{path: "go//bin/", n: 1, want: "bin", wantOK: true},
+		{path: "go/bin/go", n: 3, wantOK: false},
+		{path: "go/", n: 1, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := stripComponents(tt.path, tt.n)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("stripComponents(%q, %d) = %q, %v, want %q, %v", tt.path, tt.n, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"go.cluttr.dev/prebuilt/internal/metaerr"
+)
+
+// githubGraphQLBatchSize is the number of repositories that are queried in a
+// single GraphQL request.
+const githubGraphQLBatchSize = 20
+
+// githubGraphQLFragments select the releases or the tags of a repository,
+// newest first.
+var githubGraphQLFragments = map[string]string{
+	versionSourceReleases: githubGraphQLReleases,
+	versionSourceTags:     githubGraphQLTags,
+}
+
+// githubGraphQLReleases selects the releases of a repository like the first
+// page of the REST API does.
+const githubGraphQLReleases = `fragment releases on Repository {
+  releases(first: 100, orderBy: {field: CREATED_AT, direction: DESC}) {
+    pageInfo { hasNextPage }
+    nodes {
+      tagName
+      isPrerelease
+      isDraft
+      publishedAt
+      releaseAssets(first: 100) {
+        pageInfo { hasNextPage }
+        nodes { name }
+      }
+    }
+  }
+}`
+
+const githubGraphQLTags = `fragment tags on Repository {
+  refs(refPrefix: "refs/tags/", first: 100, orderBy: {field: TAG_COMMIT_DATE, direction: DESC}) {
+    pageInfo { hasNextPage }
+    nodes { name }
+  }
+}`
+
+type githubGraphQLRepository struct {
+	Refs struct {
+		PageInfo struct {
+			HasNextPage bool `json:"hasNextPage"`
+		} `json:"pageInfo"`
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"refs"`
+	Releases struct {
+		PageInfo struct {
+			HasNextPage bool `json:"hasNextPage"`
+		} `json:"pageInfo"`
+		Nodes []struct {
+			TagName       string     `json:"tagName"`
+			IsPrerelease  bool       `json:"isPrerelease"`
+			IsDraft       bool       `json:"isDraft"`
+			PublishedAt   *time.Time `json:"publishedAt"`
+			ReleaseAssets struct {
+				PageInfo struct {
+					HasNextPage bool `json:"hasNextPage"`
+				} `json:"pageInfo"`
+				Nodes []struct {
+					Name string `json:"name"`
+				} `json:"nodes"`
+			} `json:"releaseAssets"`
+		} `json:"nodes"`
+	} `json:"releases"`
+}
+
+// prefetchedReleases are the releases of repositories that were fetched in
+// batches ahead of resolving their binaries, keyed by releaseKey.
+type prefetchedReleases map[string]releaseList
+
+type releaseList struct {
+	releases []Release
+	// complete is set if there are no further releases, so that the lack
+	// of a match is final.
+	complete bool
+}
+
+// releaseKey identifies the releases of a repository as listed by the named
+// provider. Providers that extend another one may list them differently.
+func releaseKey(provider string, data ProviderData) string {
+	return provider + ":" + data.BaseURL + "/" + data.Project() + "?source=" + versionSource(data)
+}
+
+// lookup returns the releases of the binary's repository that were
+// prefetched for the named provider.
+func (p prefetchedReleases) lookup(provider string, data ProviderData) (releaseList, bool) {
+	list, ok := p[releaseKey(provider, data)]
+	return list, ok
+}
+
+// githubRepository is a repository to query with GraphQL.
+type githubRepository struct {
+	owner string
+	name  string
+	// source is either versionSourceReleases or versionSourceTags
+	source   string
+	provider string
+	data     ProviderData
+}
+
+// prefetchReleases queries the releases or tags of the binaries' repositories
+// from the GraphQL APIs of their providers, batching many repositories into a
+// single request. Only providers with a GraphQLURL and an auth token are
+// queried, as GitHub doesn't allow unauthenticated GraphQL requests.
+// Repositories that can't be prefetched are resolved with their VersionsURL.
+func (r *Resolver) prefetchReleases(ctx context.Context, bins []BinarySpec) prefetchedReleases {
+	type batch struct {
+		client *http.Client
+		repos  []githubRepository
+	}
+	var (
+		batches = make(map[string]*batch)
+		seen    = make(map[string]bool)
+	)
+	for _, bin := range bins {
+		prov, data, err := r.resolveProvider(bin.Provider)
+		if err != nil || prov.Spec.GraphQLURL == "" || prov.backend != nil {
+			continue
+		}
+		if prov.instanceToken(data.BaseURL) == "" || seen[releaseKey(prov.Spec.Name, data)] {
+			continue
+		}
+		owner, name := data.Host, data.Path
+		if owner == "" || name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		source := versionSource(data)
+		if _, ok := githubGraphQLFragments[source]; !ok {
+			continue
+		}
+		url, err := renderTemplate(prov.Spec.GraphQLURL, map[string]any{"Provider": data})
+		if err != nil {
+			continue
+		}
+
+		seen[releaseKey(prov.Spec.Name, data)] = true
+		b, ok := batches[url]
+		if !ok {
+			b = &batch{client: newRateLimitClient(prov.InstanceClient(data.BaseURL), r.RateLimitWait, prov.instanceTokenEnv(data.BaseURL))}
+			batches[url] = b
+		}
+		b.repos = append(b.repos, githubRepository{owner: owner, name: name, source: source, provider: prov.Spec.Name, data: data})
+	}
+
+	prefetched := make(prefetchedReleases)
+	for url, b := range batches {
+		// a single repository costs as many requests with the REST API
+		if len(b.repos) < 2 {
+			continue
+		}
+		for repos := range slices.Chunk(b.repos, githubGraphQLBatchSize) {
+			if err := queryGithubReleases(ctx, b.client, url, repos, prefetched); err != nil {
+				slog.With("error", err).
+					With(metaerr.GetMetadata(err)...).
+					Warn("failed to prefetch releases, falling back to the REST API")
+			}
+		}
+	}
+	return prefetched
+}
+
+// queryGithubReleases queries the releases or tags of the repositories in a
+// single GraphQL request and adds them to `prefetched`.
+func queryGithubReleases(ctx context.Context, client *http.Client, url string, repos []githubRepository, prefetched prefetchedReleases) error {
+	var (
+		params    []string
+		fields    []string
+		fragments = make(map[string]bool)
+		variables = make(map[string]any, 2*len(repos))
+	)
+	for i, repo := range repos {
+		params = append(params, fmt.Sprintf("$owner%d: String!, $name%d: String!", i, i))
+		fields = append(fields, fmt.Sprintf("r%d: repository(owner: $owner%d, name: $name%d) { ...%s }", i, i, i, repo.source))
+		fragments[repo.source] = true
+		variables[fmt.Sprintf("owner%d", i)] = repo.owner
+		variables[fmt.Sprintf("name%d", i)] = repo.name
+	}
+	query := fmt.Sprintf("query(%s) {\n  %s\n}", strings.Join(params, ", "), strings.Join(fields, "\n  "))
+	// unused fragments are invalid
+	for _, source := range slices.Sorted(maps.Keys(fragments)) {
+		query += "\n" + githubGraphQLFragments[source]
+	}
+
+	payload, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	body, _, err := fetchRequest(client, req)
+	if err != nil {
+		return metaerr.WithMetadata(err, "url", url)
+	}
+
+	var resp struct {
+		Data   map[string]*githubGraphQLRepository `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return metaerr.WithMetadata(fmt.Errorf("unmarshal response: %w", err), "url", url)
+	}
+	// Missing repositories are reported as errors alongside the others.
+	if resp.Data == nil && len(resp.Errors) > 0 {
+		return metaerr.WithMetadata(fmt.Errorf("query releases: %s", resp.Errors[0].Message), "url", url)
+	}
+
+	for i, repo := range repos {
+		result := resp.Data[fmt.Sprintf("r%d", i)]
+		if result == nil {
+			continue
+		}
+		if repo.source == versionSourceTags {
+			tags := make([]string, 0, len(result.Refs.Nodes))
+			for _, node := range result.Refs.Nodes {
+				tags = append(tags, node.Name)
+			}
+			prefetched[releaseKey(repo.provider, repo.data)] = releaseList{
+				releases: tagReleases(tags),
+				complete: !result.Refs.PageInfo.HasNextPage,
+			}
+			continue
+		}
+
+		releases := make([]Release, 0, len(result.Releases.Nodes))
+		for _, node := range result.Releases.Nodes {
+			release := Release{
+				Tag:        node.TagName,
+				Prerelease: node.IsPrerelease,
+				Draft:      node.IsDraft,
+			}
+			if node.PublishedAt != nil {
+				release.PublishedAt = *node.PublishedAt
+			}
+			// truncated asset lists leave the assets unknown
+			if !node.ReleaseAssets.PageInfo.HasNextPage {
+				release.Assets = make([]string, 0, len(node.ReleaseAssets.Nodes))
+				for _, asset := range node.ReleaseAssets.Nodes {
+					release.Assets = append(release.Assets, asset.Name)
+				}
+			}
+			releases = append(releases, release)
+		}
+		prefetched[releaseKey(repo.provider, repo.data)] = releaseList{
+			releases: releases,
+			complete: !result.Releases.PageInfo.HasNextPage,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGithubGraphQLPrefetch(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		graphqlCalls  int
+		restCalls     = make(map[string]int)
+		graphqlBroken bool
+	)
+	mux, srv := setupServer(t)
+	mux.HandleFunc("POST /api/graphql", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		graphqlCalls++
+		broken := graphqlBroken
+		mu.Unlock()
+		if broken {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Query     string            `json:"query"`
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, fragment := range []string{"releases", "tags"} {
+			if strings.Contains(req.Query, "..."+fragment) != strings.Contains(req.Query, "fragment "+fragment) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		repos := map[string]any{
+			"owner/a": map[string]any{
+				"releases": map[string]any{
+					"pageInfo": map[string]any{"hasNextPage": false},
+					"nodes": []map[string]any{
+						{"tagName": "v1.3.0", "isDraft": true, "releaseAssets": map[string]any{"nodes": []any{}}},
+						// more assets than the first page
+						{"tagName": "v1.2.5", "releaseAssets": map[string]any{"pageInfo": map[string]any{"hasNextPage": true}, "nodes": []any{map[string]any{"name": "tool_darwin"}}}},
+						{"tagName": "v1.2.0", "publishedAt": "2024-05-01T00:00:00Z", "releaseAssets": map[string]any{"nodes": []any{map[string]any{"name": "tool_linux"}}}},
+					},
+				},
+				"refs": map[string]any{
+					"pageInfo": map[string]any{"hasNextPage": false},
+					"nodes":    []map[string]any{{"name": "v2.1.0"}, {"name": "v1.4.0"}},
+				},
+			},
+			"owner/b": map[string]any{
+				"releases": map[string]any{
+					"pageInfo": map[string]any{"hasNextPage": true},
+					"nodes": []map[string]any{
+						{"tagName": "v2.0.0", "releaseAssets": map[string]any{"nodes": []any{map[string]any{"name": "tool_linux"}}}},
+					},
+				},
+				// tags are listed by name, so a later page may hold newer ones
+				"refs": map[string]any{
+					"pageInfo": map[string]any{"hasNextPage": true},
+					"nodes":    []map[string]any{{"name": "v1.0.1"}},
+				},
+			},
+		}
+		data := make(map[string]any)
+		var errs []map[string]any
+		for i := 0; ; i++ {
+			owner, ok := req.Variables[fmt.Sprintf("owner%d", i)]
+			if !ok {
+				break
+			}
+			name := req.Variables[fmt.Sprintf("name%d", i)]
+			if repo, ok := repos[owner+"/"+name]; ok {
+				data[fmt.Sprintf("r%d", i)] = repo
+			} else {
+				data[fmt.Sprintf("r%d", i)] = nil
+				errs = append(errs, map[string]any{"type": "NOT_FOUND", "message": "Could not resolve to a Repository"})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": data, "errors": errs})
+	})
+	mux.HandleFunc("GET /api/v3/repos/owner/{repo}/releases", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		restCalls[r.PathValue("repo")]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[
+			{"tag_name": "v2.0.0", "assets": [{"name": "tool_linux"}]},
+			{"tag_name": "v1.5.0", "assets": [{"name": "tool_linux"}]},
+			{"tag_name": "v1.2.0", "assets": [{"name": "tool_linux"}]}
+		]`))
+	})
+	mux.HandleFunc("GET /api/v3/repos/owner/{repo}/tags", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		restCalls[r.PathValue("repo")+"/tags"]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"name": "v1.2.0"}]`))
+	})
+
+	bins := []BinarySpec{
+		{Name: "a", Version: Version{String: ptr("latest")}, Provider: ProviderConfig{DSN: ptr("ghe://owner/a?asset=tool_linux")}},
+		{Name: "b", Version: Version{String: ptr("^1")}, Provider: ProviderConfig{DSN: ptr("ghe://owner/b?asset=tool_linux")}},
+		{Name: "c", Version: Version{String: ptr("latest")}, Provider: ProviderConfig{DSN: ptr("ghe://owner/c?asset=tool_linux")}},
+		{Name: "d", Version: Version{String: ptr("^1")}, Provider: ProviderConfig{DSN: ptr("ghe://owner/a?source=tags&asset=tool_linux")}},
+		// the same repository, but listed by a provider with its own regex
+		{Name: "e", Version: Version{String: ptr("latest")}, Provider: ProviderConfig{DSN: ptr("ghe-bare://owner/a?asset=tool_linux")}},
+		{Name: "f", Version: Version{String: ptr("^1")}, Provider: ProviderConfig{DSN: ptr("ghe://owner/b?source=tags&asset=tool_linux")}},
+	}
+
+	tests := []struct {
+		testName         string
+		token            string
+		broken           bool
+		want             map[string]string
+		wantGraphQLCalls int
+		wantRESTCalls    map[string]int
+	}{
+		{
+			testName:         "prefetch",
+			token:            "token",
+			want:             map[string]string{"a": "v1.2.5", "b": "v1.5.0", "c": "v2.0.0", "d": "v1.4.0", "e": "2.0.0", "f": "v1.2.0"},
+			wantGraphQLCalls: 1,
+			wantRESTCalls:    map[string]int{"a": 1, "b": 1, "b/tags": 1, "c": 1},
+		},
+		{
+			testName:      "without token",
+			want:          map[string]string{"a": "v2.0.0", "b": "v1.5.0", "c": "v2.0.0", "d": "v1.2.0", "e": "2.0.0", "f": "v1.2.0"},
+			wantRESTCalls: map[string]int{"a": 2, "a/tags": 1, "b": 1, "b/tags": 1, "c": 1},
+		},
+		{
+			testName:         "graphql error",
+			token:            "token",
+			broken:           true,
+			want:             map[string]string{"a": "v2.0.0", "b": "v1.5.0", "c": "v2.0.0", "d": "v1.2.0", "e": "2.0.0", "f": "v1.2.0"},
+			wantGraphQLCalls: 1,
+			wantRESTCalls:    map[string]int{"a": 2, "a/tags": 1, "b": 1, "b/tags": 1, "c": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			graphqlCalls, graphqlBroken = 0, tt.broken
+			clear(restCalls)
+
+			specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+				Name:    "ghe",
+				Extends: "github",
+				BaseURL: srv.URL,
+			}, ProviderSpec{
+				Name:          "ghe-bare",
+				Extends:       "ghe",
+				VersionsRegex: `^v(?P<version>.+)$`,
+			})
+			providers, err := InitProviders(specs, AuthTokens{Providers: map[string]string{"ghe": tt.token, "ghe-bare": tt.token}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := Resolver{Providers: providers}
+
+			lock, err := r.Resolve(context.Background(), bins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, b := range lock.Binaries {
+				got[b.Name] = b.Version
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("versions = %v, want %v", got, tt.want)
+			}
+			if graphqlCalls != tt.wantGraphQLCalls {
+				t.Errorf("expected %d GraphQL calls, got %d", tt.wantGraphQLCalls, graphqlCalls)
+			}
+			if fmt.Sprint(restCalls) != fmt.Sprint(tt.wantRESTCalls) {
+				t.Errorf("REST calls = %v, want %v", restCalls, tt.wantRESTCalls)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ require (
 	github.com/cluttrdev/cli v0.0.0-20250719095132-732c23cca50b
 	github.com/goccy/go-yaml v1.17.1
 	github.com/pterm/pterm v0.12.80
+	golang.org/x/sync v0.16.0
 )
 
 require github.com/google/go-cmp v0.7.0
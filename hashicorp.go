@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	_url "net/url"
+	"runtime"
+	"strings"
+
+	"go.cluttr.dev/prebuilt/internal/metaerr"
+)
+
+type hashicorpBuild struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+}
+
+type hashicorpRelease struct {
+	Name    string           `json:"name"`
+	Version string           `json:"version"`
+	SHASums string           `json:"shasums"`
+	Builds  []hashicorpBuild `json:"builds"`
+}
+
+// hashicorpBackend lists the versions of a product from the index of the
+// HashiCorp releases site and locates the build for the host platform.
+//
+// DSNs have the form `hashicorp://product`. The `os` and `arch` parameters
+// select another platform.
+type hashicorpBackend struct{}
+
+func (b hashicorpBackend) ListVersions(ctx context.Context, client *http.Client, data ProviderData) ([]string, error) {
+	url := b.productURL(data) + "/index.json"
+	body, _, err := fetch(ctx, client, url)
+	if err != nil {
+		return nil, metaerr.WithMetadata(err, "url", url)
+	}
+
+	var index struct {
+		Versions map[string]struct {
+			Version string `json:"version"`
+		} `json:"versions"`
+	}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, metaerr.WithMetadata(fmt.Errorf("unmarshal index: %w", err), "url", url)
+	}
+
+	versions := make([]string, 0, len(index.Versions))
+	for key, v := range index.Versions {
+		versions = append(versions, cmp.Or(v.Version, key))
+	}
+	return versions, nil
+}
+
+func (b hashicorpBackend) LocateAsset(ctx context.Context, client *http.Client, data ProviderData, version Release) (Asset, error) {
+	versionURL := b.productURL(data) + "/" + _url.PathEscape(version.Tag)
+
+	url := versionURL + "/index.json"
+	body, _, err := fetch(ctx, client, url)
+	if err != nil {
+		return Asset{}, metaerr.WithMetadata(err, "url", url)
+	}
+	var release hashicorpRelease
+	if err := json.Unmarshal(body, &release); err != nil {
+		return Asset{}, metaerr.WithMetadata(fmt.Errorf("unmarshal release: %w", err), "url", url)
+	}
+
+	goos := cmp.Or(data.Values["os"], runtime.GOOS)
+	goarch := cmp.Or(data.Values["arch"], runtime.GOARCH)
+	var build *hashicorpBuild
+	for i := range release.Builds {
+		if release.Builds[i].OS == goos && release.Builds[i].Arch == goarch {
+			build = &release.Builds[i]
+			break
+		}
+	}
+	if build == nil {
+		return Asset{}, fmt.Errorf("no build for platform: %s/%s", goos, goarch)
+	}
+	if u, err := _url.Parse(build.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return Asset{}, fmt.Errorf("invalid build url: %q", build.URL)
+	}
+
+	url = versionURL + "/" + _url.PathEscape(release.SHASums)
+	body, _, err = fetch(ctx, client, url)
+	if err != nil {
+		return Asset{}, metaerr.WithMetadata(fmt.Errorf("get checksums: %w", err), "url", url)
+	}
+	checksum, ok := findChecksum(body, build.Filename)
+	if !ok {
+		return Asset{}, metaerr.WithMetadata(fmt.Errorf("missing checksum: %s", build.Filename), "url", url)
+	}
+
+	extractPath := cmp.Or(build.Name, release.Name, data.Name())
+	if build.OS == "windows" {
+		extractPath += ".exe"
+	}
+
+	return Asset{
+		URL:         build.URL,
+		Filename:    build.Filename,
+		Checksum:    "sha256:" + checksum,
+		ExtractPath: extractPath,
+	}, nil
+}
+
+func (b hashicorpBackend) productURL(data ProviderData) string {
+	return data.BaseURL + "/" + _url.PathEscape(data.Name())
+}
+
+// findChecksum returns the checksum of the file from the output of a tool like
+// `sha256sum`, with lines of the form `<checksum>  <filename>`.
+func findChecksum(sums []byte, filename string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		checksum, name, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		// binary mode is marked with an asterisk
+		if strings.TrimPrefix(strings.TrimSpace(name), "*") == filename {
+			return checksum, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHashicorpProvider(t *testing.T) {
+	archive, err := os.ReadFile(writeZip(t, t.TempDir(), []archiveEntry{
+		{name: "LICENSE.txt", body: "license"},
+		{name: "terraform", body: "terraform 1.5.7"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(archive)
+	checksum := hex.EncodeToString(sum[:])
+	filename := fmt.Sprintf("terraform_1.5.7_%s_%s.zip", runtime.GOOS, runtime.GOARCH)
+
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /terraform/index.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "terraform", "versions": {
+			"1.5.7": {"name": "terraform", "version": "1.5.7"},
+			"1.6.0-beta1": {"name": "terraform", "version": "1.6.0-beta1"},
+			"1.4.6": {"name": "terraform", "version": "1.4.6"}
+		}}`))
+	})
+	mux.HandleFunc("GET /terraform/1.5.7/index.json", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(hashicorpRelease{
+			Name:    "terraform",
+			Version: "1.5.7",
+			SHASums: "terraform_1.5.7_SHA256SUMS",
+			Builds: []hashicorpBuild{
+				{Name: "terraform", Version: "1.5.7", OS: "plan9", Arch: "386", Filename: "terraform_1.5.7_plan9_386.zip", URL: srv.URL + "/terraform/1.5.7/terraform_1.5.7_plan9_386.zip"},
+				{Name: "terraform", Version: "1.5.7", OS: runtime.GOOS, Arch: runtime.GOARCH, Filename: filename, URL: srv.URL + "/terraform/1.5.7/" + filename},
+				{Name: "terraform", Version: "1.5.7", OS: "windows", Arch: "arm64", Filename: "terraform_1.5.7_windows_arm64.zip", URL: srv.URL + "/terraform/1.5.7/terraform_1.5.7_windows_arm64.zip"},
+			},
+		})
+	})
+	mux.HandleFunc("GET /terraform/1.5.7/terraform_1.5.7_SHA256SUMS", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%064x  terraform_1.5.7_plan9_386.zip\n%s  %s\n%064x  terraform_1.5.7_windows_arm64.zip\n", 0, checksum, filename, 1)
+	})
+	mux.HandleFunc("GET /terraform/1.5.7/"+filename, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	})
+
+	specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+		Name:    "hashicorp-mirror",
+		Extends: "hashicorp",
+		BaseURL: srv.URL,
+	})
+	providers, err := InitProviders(specs, AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Resolver{Providers: providers}
+
+	data, err := r.resolve(context.Background(), BinarySpec{
+		Version:  Version{String: ptr("~1.5")},
+		Provider: ProviderConfig{DSN: ptr("hashicorp-mirror://terraform")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := BinaryData{
+		Name:        "terraform",
+		Provider:    "hashicorp-mirror",
+		Version:     "1.5.7",
+		DownloadURL: srv.URL + "/terraform/1.5.7/" + filename,
+		Filename:    filename,
+		Checksum:    "sha256:" + checksum,
+		ExtractPath: "terraform",
+	}
+	if diff := cmp.Diff(want, data); diff != "" {
+		t.Fatalf("resolve() mismatch (-want +got):\n%s", diff)
+	}
+
+	// windows executables have an extension
+	windows, err := r.resolve(context.Background(), BinarySpec{
+		Version:  Version{String: ptr("~1.5")},
+		Provider: ProviderConfig{DSN: ptr("hashicorp-mirror://terraform?os=windows&arch=arm64")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if windows.ExtractPath != "terraform.exe" {
+		t.Errorf("resolve() windows extract path = %q, want %q", windows.ExtractPath, "terraform.exe")
+	}
+
+	t.Setenv("PREBUILT_DATA_HOME", t.TempDir())
+	installDir := t.TempDir()
+	c := installCmd{
+		resolver:  r,
+		newStatus: func(string) statusPrinter { return nopStatus{} },
+	}
+	if err := c.install(context.Background(), []BinaryData{data}, installDir); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(installDir, "terraform")); string(content) != "terraform 1.5.7" {
+		t.Errorf("installed %q, want %q", content, "terraform 1.5.7")
+	}
+
+	// tampered downloads are rejected
+	data.Checksum = fmt.Sprintf("sha256:%064x", 0)
+	if err := c.install(context.Background(), []BinaryData{data}, installDir); err == nil {
+		t.Error("install() succeeded with checksum mismatch")
+	}
+}
+
+func Test_findChecksum(t *testing.T) {
+	sums := []byte("aaaa  tool_linux_amd64.zip\nbbbb *tool_darwin_arm64.zip\n\ncccc  tool_linux_amd64.zip.sig\n")
+
+	tests := []struct {
+		filename string
+		want     string
+		wantOK   bool
+	}{
+		{filename: "tool_linux_amd64.zip", want: "aaaa", wantOK: true},
+		{filename: "tool_darwin_arm64.zip", want: "bbbb", wantOK: true},
+		{filename: "tool_windows_amd64.zip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			got, ok := findChecksum(sums, tt.filename)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("findChecksum() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"runtime"
+	"strconv"
 	"time"
 )
 
@@ -50,3 +54,157 @@ func (t *authedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	return t.Transport.RoundTrip(req)
 }
+
+// RateLimitError is returned for requests that exceeded the rate limit of an
+// API.
+type RateLimitError struct {
+	Host string
+	// Reset is the time the rate limit resets, if known.
+	Reset time.Time
+	// TokenEnv is the environment variable that configures an auth token for
+	// the API, if known.
+	TokenEnv string
+	// Authenticated tells whether the request was sent with credentials.
+	Authenticated bool
+}
+
+func (e *RateLimitError) Error() string {
+	msg := fmt.Sprintf("rate limit of %s exceeded", e.Host)
+	if !e.Reset.IsZero() {
+		msg += fmt.Sprintf(", resets at %s (in %s)",
+			e.Reset.Local().Format(time.TimeOnly),
+			time.Until(e.Reset).Round(time.Second),
+		)
+	}
+	if e.Authenticated {
+		return msg
+	}
+	if e.TokenEnv != "" {
+		msg += fmt.Sprintf("; set %s to use the higher limit for authenticated requests", e.TokenEnv)
+	} else {
+		msg += "; configure an auth token for the host to use the higher limit for authenticated requests"
+	}
+	return msg + ", or retry with --rate-limit-wait"
+}
+
+// maxRateLimitRetries is the number of times a request is retried after
+// waiting for the rate limit to reset, which may not help if clocks are
+// skewed.
+const maxRateLimitRetries = 3
+
+// newRateLimitClient returns a client that fails requests with a
+// *RateLimitError if the rate limit of the API is exceeded, or waits for the
+// rate limit to reset if that takes at most `maxWait`. The error suggests to
+// configure an auth token with `tokenEnv`, if given.
+func newRateLimitClient(client *http.Client, maxWait time.Duration, tokenEnv string) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	limited := *client
+	limited.Transport = &rateLimitTransport{RoundTripper: transport, maxWait: maxWait, tokenEnv: tokenEnv}
+	return &limited
+}
+
+type rateLimitTransport struct {
+	http.RoundTripper
+	maxWait  time.Duration
+	tokenEnv string
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	deadline := time.Now().Add(t.maxWait)
+	for retries := 0; ; retries++ {
+		resp, err := t.RoundTripper.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		rateErr := rateLimitError(resp)
+		if rateErr == nil {
+			return resp, nil
+		}
+		rateErr.TokenEnv = t.tokenEnv
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		canRetry := req.Body == nil || req.GetBody != nil
+		if rateErr.Reset.IsZero() || rateErr.Reset.After(deadline) || !canRetry {
+			return nil, rateErr
+		}
+		// resets in the past don't need waiting, but must not retry forever
+		if !time.Now().Before(deadline) || retries >= maxRateLimitRetries {
+			return nil, rateErr
+		}
+
+		wait := max(time.Until(rateErr.Reset), 0)
+		slog.Warn("rate limit exceeded, waiting for reset", "host", rateErr.Host, "wait", wait.Round(time.Second))
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// rateLimitError returns the error for responses that indicate an exceeded
+// rate limit, or nil. It recognises the `Retry-After` header as well as the
+// `X-RateLimit-*` headers of GitHub and the `RateLimit-*` headers of GitLab.
+func rateLimitError(resp *http.Response) *RateLimitError {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	err := &RateLimitError{
+		Host:          resp.Request.URL.Host,
+		Authenticated: resp.Request.Header.Get("Authorization") != "",
+	}
+	switch {
+	case resp.Header.Get("X-RateLimit-Remaining") == "0":
+		err.Reset = parseUnixTime(resp.Header.Get("X-RateLimit-Reset"))
+	case resp.Header.Get("RateLimit-Remaining") == "0":
+		err.Reset = parseUnixTime(resp.Header.Get("RateLimit-Reset"))
+	case resp.Header.Get("Retry-After") != "":
+		// e.g. the secondary rate limits of GitHub
+	case resp.StatusCode == http.StatusTooManyRequests:
+	default:
+		return nil
+	}
+
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		if seconds, perr := strconv.Atoi(after); perr == nil {
+			err.Reset = time.Now().Add(time.Duration(seconds) * time.Second)
+		} else if t, perr := http.ParseTime(after); perr == nil {
+			err.Reset = t
+		}
+	}
+	return err
+}
+
+// parseUnixTime parses a unix timestamp in seconds, or returns the zero time.
+func parseUnixTime(s string) time.Time {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// originHost returns the host of the request that started the redirect chain
+// of req.
+func originHost(req *http.Request) string {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req.URL.Host
+}
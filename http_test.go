@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_rateLimitError(t *testing.T) {
+	reset := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	tests := []struct {
+		testName  string
+		status    int
+		header    map[string]string
+		want      bool
+		wantReset time.Time
+	}{
+		{
+			testName:  "github",
+			status:    http.StatusForbidden,
+			header:    map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+			want:      true,
+			wantReset: reset,
+		},
+		{
+			testName:  "gitlab",
+			status:    http.StatusTooManyRequests,
+			header:    map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+			want:      true,
+			wantReset: reset,
+		},
+		{
+			testName:  "retry after date",
+			status:    http.StatusTooManyRequests,
+			header:    map[string]string{"Retry-After": reset.UTC().Format(http.TimeFormat)},
+			want:      true,
+			wantReset: reset,
+		},
+		{
+			testName: "github secondary rate limit",
+			status:   http.StatusForbidden,
+			header:   map[string]string{"Retry-After": "60", "X-GitHub-Request-Id": "1234"},
+			want:     true,
+		},
+		{
+			testName: "too many requests",
+			status:   http.StatusTooManyRequests,
+			want:     true,
+		},
+		{
+			testName: "forbidden",
+			status:   http.StatusForbidden,
+			header:   map[string]string{"X-RateLimit-Remaining": "42"},
+		},
+		{
+			testName: "ok",
+			status:   http.StatusOK,
+			header:   map[string]string{"X-RateLimit-Remaining": "0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/releases", nil)
+			resp := &http.Response{StatusCode: tt.status, Header: make(http.Header), Request: req}
+			for key, value := range tt.header {
+				resp.Header.Set(key, value)
+			}
+
+			got := rateLimitError(resp)
+			if (got != nil) != tt.want {
+				t.Fatalf("rateLimitError() = %v, want rate limit error: %v", got, tt.want)
+			}
+			if got == nil {
+				return
+			}
+			if !tt.wantReset.IsZero() && !got.Reset.Equal(tt.wantReset) {
+				t.Errorf("Reset = %v, want %v", got.Reset, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestRateLimitClient(t *testing.T) {
+	var requests int
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /releases", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"tag_name": "v1.0.0"}]`))
+	})
+
+	t.Run("fail", func(t *testing.T) {
+		mux.HandleFunc("GET /limited", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+		})
+		client := newRateLimitClient(srv.Client(), time.Minute, "CORP_GITHUB_TOKEN")
+		_, err := GetVersions(context.Background(), client, srv.URL+"/limited", VersionsQuery{JSONPath: "$[*].tag_name"})
+		var rateErr *RateLimitError
+		if !errors.As(err, &rateErr) {
+			t.Fatalf("GetVersions() error = %v, want *RateLimitError", err)
+		}
+		if !strings.Contains(err.Error(), "CORP_GITHUB_TOKEN") {
+			t.Errorf("error %q doesn't mention CORP_GITHUB_TOKEN", err)
+		}
+	})
+
+	t.Run("fail authenticated", func(t *testing.T) {
+		client := newRateLimitClient(newAuthedClient("secret"), time.Minute, "CORP_GITHUB_TOKEN")
+		_, err := GetVersions(context.Background(), client, srv.URL+"/limited", VersionsQuery{JSONPath: "$[*].tag_name"})
+		var rateErr *RateLimitError
+		if !errors.As(err, &rateErr) {
+			t.Fatalf("GetVersions() error = %v, want *RateLimitError", err)
+		}
+		if !rateErr.Authenticated || strings.Contains(err.Error(), "CORP_GITHUB_TOKEN") || !strings.Contains(err.Error(), "resets at") {
+			t.Errorf("error %q suggests a token although one is configured", err)
+		}
+	})
+
+	// a reset in the past, e.g. due to clock skew, must not retry forever
+	var pastRequests int
+	mux.HandleFunc("GET /past", func(w http.ResponseWriter, r *http.Request) {
+		pastRequests++
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+	})
+	for _, tt := range []struct {
+		maxWait      time.Duration
+		wantRequests int
+	}{
+		{maxWait: 0, wantRequests: 1},
+		{maxWait: time.Minute, wantRequests: 1 + maxRateLimitRetries},
+	} {
+		t.Run("past reset/"+tt.maxWait.String(), func(t *testing.T) {
+			pastRequests = 0
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			client := newRateLimitClient(srv.Client(), tt.maxWait, "")
+			_, err := GetVersions(ctx, client, srv.URL+"/past", VersionsQuery{JSONPath: "$[*].tag_name"})
+			var rateErr *RateLimitError
+			if !errors.As(err, &rateErr) {
+				t.Fatalf("GetVersions() error = %v, want *RateLimitError", err)
+			}
+			if pastRequests != tt.wantRequests {
+				t.Errorf("expected %d requests, got %d", tt.wantRequests, pastRequests)
+			}
+		})
+	}
+
+	t.Run("wait", func(t *testing.T) {
+		requests = 0
+		client := newRateLimitClient(srv.Client(), time.Minute, "")
+		got, err := GetVersions(context.Background(), client, srv.URL+"/releases", VersionsQuery{JSONPath: "$[*].tag_name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0] != "v1.0.0" {
+			t.Errorf("GetVersions() = %v, want [v1.0.0]", got)
+		}
+		if requests != 2 {
+			t.Errorf("expected 2 requests, got %d", requests)
+		}
+	})
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-// Install copies the source file to the destination file
-// and sets the destination file's permissions to `rwxr-x--x`.
-func Install(src string, dst string) error {
+// Install copies the source file to the destination file.
+// If mode is zero, the destination file's permissions are set to `rwxrwxrwx`
+// minus the umask, otherwise they are set to mode exactly.
+func Install(src string, dst string, mode os.FileMode) error {
 	ifile, err := os.Open(src)
 	if err != nil {
 		return err
@@ -23,7 +28,15 @@ func Install(src string, dst string) error {
 
 	// write src to new temporary dst
 	dstNew := filepath.Join(dstDir, fmt.Sprintf(".%s.new", dstName))
-	ofile, err := os.OpenFile(dstNew, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+
+	// delete left-over new file, so that it's created with the desired mode
+	_ = os.Remove(dstNew)
+
+	perm := mode
+	if perm == 0 {
+		perm = 0777
+	}
+	ofile, err := os.OpenFile(dstNew, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
@@ -31,6 +44,13 @@ func Install(src string, dst string) error {
 		_ = ofile.Close()
 	}()
 
+	if mode != 0 {
+		// bypass the umask
+		if err := ofile.Chmod(mode); err != nil {
+			return err
+		}
+	}
+
 	_, err = io.Copy(ofile, ifile)
 	if err != nil {
 		return err
@@ -58,3 +78,265 @@ func Install(src string, dst string) error {
 
 	return nil
 }
+
+// InstallTree unpacks the archive into the directory dst, replacing any
+// existing directory tree at that location.
+// The first `strip` leading path components of every archive entry are
+// removed, like `tar --strip-components` does.
+// If `preserveMode` is set, file permissions are taken from the archive
+// as-is, otherwise they are subject to the umask.
+func InstallTree(archive string, dst string, strip int, preserveMode bool) error {
+	dstDir := filepath.Dir(dst)
+	dstName := filepath.Base(dst)
+
+	if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// unpack into new temporary dst
+	dstNew := filepath.Join(dstDir, fmt.Sprintf(".%s.new", dstName))
+
+	// delete left-over new tree
+	if err := os.RemoveAll(dstNew); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dstNew, os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dstNew)
+	}()
+
+	if err := ExtractAll(archive, dstNew, strip, preserveMode); err != nil {
+		return err
+	}
+
+	// remove existing tree
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+
+	// move the new tree
+	if err := os.Rename(dstNew, dst); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Link creates a symbolic link at `link` that points to `target`.
+// An existing file at `link` is replaced atomically.
+func Link(target string, link string) error {
+	linkDir := filepath.Dir(link)
+	linkName := filepath.Base(link)
+
+	linkNew := filepath.Join(linkDir, fmt.Sprintf(".%s.new", linkName))
+
+	// delete left-over new link
+	_ = os.Remove(linkNew)
+
+	if err := os.Symlink(target, linkNew); err != nil {
+		return err
+	}
+
+	// move the new link
+	if err := os.Rename(linkNew, link); err != nil {
+		_ = os.Remove(linkNew)
+		return err
+	}
+
+	return nil
+}
+
+// treeExecutables returns the paths, relative to `dir`, of all executable
+// files within the tree's `bin` directory.
+func treeExecutables(dir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "bin"))
+	if err != nil {
+		return nil, err
+	}
+
+	var bins []string
+	for _, entry := range entries {
+		info, err := os.Stat(filepath.Join(dir, "bin", entry.Name()))
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		bins = append(bins, filepath.Join("bin", entry.Name()))
+	}
+	return bins, nil
+}
+
+// linksInto returns the symbolic links within `dir` that point to a location
+// below `root`, mapped to their absolute targets.
+func linksInto(dir string, root string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make(map[string]string)
+	for _, entry := range entries {
+		// skip non-links as well as hidden backups
+		if entry.Type()&os.ModeSymlink == 0 || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		link := filepath.Join(dir, entry.Name())
+		target, err := os.Readlink(link)
+		if err != nil {
+			return nil, err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(dir, target)
+		}
+		rel, err := filepath.Rel(root, target)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		links[link] = target
+	}
+	return links, nil
+}
+
+// parseFileMode parses a file mode setting, which is either empty, the
+// keyword `preserve` or an octal permission mode like `0750`.
+func parseFileMode(s string) (mode os.FileMode, preserve bool, err error) {
+	switch s {
+	case "":
+		return 0, false, nil
+	case fileModePreserve:
+		return 0, true, nil
+	}
+
+	perm, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || perm == 0 || perm > 0777 {
+		return 0, false, fmt.Errorf("invalid file mode: %s", s)
+	}
+	return os.FileMode(perm), false, nil
+}
+
+// lookupGroup returns the id of the group given by name or numeric id, or -1
+// if it is empty.
+func lookupGroup(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	if gid, err := strconv.Atoi(s); err == nil && gid >= 0 {
+		return gid, nil
+	}
+	group, err := user.LookupGroup(s)
+	if err != nil {
+		return -1, fmt.Errorf("invalid group: %w", err)
+	}
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		return -1, fmt.Errorf("invalid group: %s: unsupported id %s", s, group.Gid)
+	}
+	return gid, nil
+}
+
+// ChownGroup changes the group of the file at path, or of all files below it
+// if it is a directory. Symbolic links are changed themselves rather than
+// their targets. A negative gid leaves the group unchanged.
+func ChownGroup(path string, gid int) error {
+	if gid < 0 {
+		return nil
+	}
+	return filepath.WalkDir(path, func(p string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, -1, gid)
+	})
+}
+
+// SwapDir moves the directory `src` to `dst`, keeping a backup of an existing
+// directory at `dst` as `.<dst>.old`.
+// The returned function moves the directories back to where they were.
+func SwapDir(src string, dst string) (func() error, error) {
+	dstOld := backupPath(dst)
+
+	// delete left-over old directory
+	if err := os.RemoveAll(dstOld); err != nil {
+		return nil, err
+	}
+
+	existed := false
+	if _, err := os.Lstat(dst); err == nil {
+		existed = true
+		if err := os.Rename(dst, dstOld); err != nil {
+			return nil, err
+		}
+	}
+
+	restore := func() error {
+		if err := os.Rename(dst, src); err != nil {
+			return err
+		}
+		if existed {
+			return os.Rename(dstOld, dst)
+		}
+		return nil
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		if existed {
+			_ = os.Rename(dstOld, dst)
+		}
+		return nil, err
+	}
+
+	return restore, nil
+}
+
+// backupPath returns the path of the backup that SwapDir keeps of `path`.
+func backupPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.old", filepath.Base(path)))
+}
+
+// SwapLink replaces the file at `link` with a symbolic link to `target`,
+// keeping a backup of the previous file as `.<link>.old`.
+// The returned function restores the previous file from its backup.
+func SwapLink(target string, link string) (func() error, error) {
+	linkDir := filepath.Dir(link)
+	linkName := filepath.Base(link)
+
+	linkOld := filepath.Join(linkDir, fmt.Sprintf(".%s.old", linkName))
+
+	// delete existing old file
+	_ = os.Remove(linkOld)
+
+	// back up existing file, without moving it out of the way
+	existed := false
+	if info, err := os.Lstat(link); err == nil {
+		existed = true
+		if info.Mode()&os.ModeSymlink != 0 {
+			dest, err := os.Readlink(link)
+			if err != nil {
+				return nil, err
+			}
+			err = os.Symlink(dest, linkOld)
+			if err != nil {
+				return nil, err
+			}
+		} else if err := os.Link(link, linkOld); err != nil {
+			return nil, err
+		}
+	}
+
+	restore := func() error {
+		if existed {
+			return os.Rename(linkOld, link)
+		}
+		return os.Remove(link)
+	}
+
+	if err := Link(target, link); err != nil {
+		if existed {
+			_ = os.Remove(linkOld)
+		}
+		return nil, err
+	}
+
+	return restore, nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInstall(t *testing.T) {
+	// determine the umask-aware default
+	probe := filepath.Join(t.TempDir(), "probe")
+	if err := os.WriteFile(probe, nil, 0777); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		testName string
+		mode     os.FileMode
+		want     os.FileMode
+	}{
+		{testName: "default", mode: 0, want: info.Mode().Perm()},
+		{testName: "explicit", mode: 0750, want: 0750},
+		{testName: "explicit beyond umask", mode: 0777, want: 0777},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			dst := filepath.Join(dir, "dst")
+			if err := os.WriteFile(src, []byte("binary"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			// an existing file is replaced
+			if err := os.WriteFile(dst, []byte("old"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Install(src, dst, tt.mode); err != nil {
+				t.Fatalf("Install() failed: %v", err)
+			}
+
+			info, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != tt.want {
+				t.Errorf("Install() mode = %v, want %v", got, tt.want)
+			}
+			if data, _ := os.ReadFile(dst); string(data) != "binary" {
+				t.Errorf("Install() content = %q, want %q", data, "binary")
+			}
+		})
+	}
+}
+
+func Test_parseFileMode(t *testing.T) {
+	tests := []struct {
+		s            string
+		wantMode     os.FileMode
+		wantPreserve bool
+		wantErr      bool
+	}{
+		{s: ""},
+		{s: "preserve", wantPreserve: true},
+		{s: "0750", wantMode: 0750},
+		{s: "644", wantMode: 0644},
+		{s: "0", wantErr: true},
+		{s: "0800", wantErr: true},
+		{s: "04755", wantErr: true},
+		{s: "rwxr-x---", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			mode, preserve, gotErr := parseFileMode(tt.s)
+			if gotErr != nil {
+				if !tt.wantErr {
+					t.Errorf("parseFileMode() failed: %v", gotErr)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("parseFileMode() succeeded unexpectedly")
+			}
+			if mode != tt.wantMode || preserve != tt.wantPreserve {
+				t.Errorf("parseFileMode() = (%v, %v), want (%v, %v)", mode, preserve, tt.wantMode, tt.wantPreserve)
+			}
+		})
+	}
+}
+
+func Test_lookupGroup(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int
+		wantErr bool
+	}{
+		{s: "", want: -1},
+		{s: "0", want: 0},
+		{s: "1234", want: 1234},
+		{s: "-1", wantErr: true},
+		{s: "no-such-group-prebuilt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, gotErr := lookupGroup(tt.s)
+			if gotErr != nil {
+				if !tt.wantErr {
+					t.Errorf("lookupGroup() failed: %v", gotErr)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("lookupGroup() succeeded unexpectedly")
+			}
+			if got != tt.want {
+				t.Errorf("lookupGroup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSwapDir(t *testing.T) {
+	tests := []struct {
+		testName string
+		existing string // content of a file in an existing directory, if any
+	}{
+		{testName: "no existing directory"},
+		{testName: "existing directory", existing: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, ".v1.0.0.staged")
+			dst := filepath.Join(dir, "v1.0.0")
+			if err := os.Mkdir(src, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "bin"), []byte("new"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing != "" {
+				if err := os.Mkdir(dst, 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dst, "bin"), []byte(tt.existing), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			restore, err := SwapDir(src, dst)
+			if err != nil {
+				t.Fatalf("SwapDir() failed: %v", err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(dst, "bin")); string(data) != "new" {
+				t.Errorf("SwapDir() content = %q, want %q", data, "new")
+			}
+
+			if err := restore(); err != nil {
+				t.Fatalf("restore() failed: %v", err)
+			}
+			if data, _ := os.ReadFile(filepath.Join(src, "bin")); string(data) != "new" {
+				t.Errorf("restore() staged content = %q, want %q", data, "new")
+			}
+			data, err := os.ReadFile(filepath.Join(dst, "bin"))
+			if tt.existing == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("restore() left directory in place: %v", err)
+				}
+			} else if string(data) != tt.existing {
+				t.Errorf("restore() content = %q, want %q", data, tt.existing)
+			}
+		})
+	}
+}
+
+func TestSwapLink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, []byte("new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		testName string
+		existing string // content of an existing file, if any
+	}{
+		{testName: "no existing file"},
+		{testName: "existing file", existing: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			link := filepath.Join(t.TempDir(), "bin")
+			if tt.existing != "" {
+				if err := os.WriteFile(link, []byte(tt.existing), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			restore, err := SwapLink(target, link)
+			if err != nil {
+				t.Fatalf("SwapLink() failed: %v", err)
+			}
+			if data, _ := os.ReadFile(link); string(data) != "new" {
+				t.Errorf("SwapLink() content = %q, want %q", data, "new")
+			}
+
+			if err := restore(); err != nil {
+				t.Fatalf("restore() failed: %v", err)
+			}
+			data, err := os.ReadFile(link)
+			if tt.existing == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("restore() left link in place: %v", err)
+				}
+			} else if string(data) != tt.existing {
+				t.Errorf("restore() content = %q, want %q", data, tt.existing)
+			}
+		})
+	}
+}
+
+func Test_commitAll(t *testing.T) {
+	dir := t.TempDir()
+	installDir := filepath.Join(dir, "bin")
+	if err := os.MkdirAll(installDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("new"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(installDir, name), []byte("old"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	staged := []stagedBinary{
+		{name: "a", links: []stagedLink{{target: filepath.Join(dir, "a"), link: filepath.Join(installDir, "a")}}},
+		{name: "b", links: []stagedLink{{target: filepath.Join(dir, "b"), link: filepath.Join(installDir, "b")}}},
+		// fails, since the install directory doesn't exist
+		{name: "c", links: []stagedLink{{target: filepath.Join(dir, "c"), link: filepath.Join(dir, "missing", "c")}}},
+	}
+	if err := commitAll(staged); err == nil {
+		t.Fatal("commitAll() succeeded unexpectedly")
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if data, _ := os.ReadFile(filepath.Join(installDir, name)); string(data) != "old" {
+			t.Errorf("%s content = %q, want %q", name, data, "old")
+		}
+	}
+}
+
+func TestInstallTree(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeTarGz(t, dir, []archiveEntry{
+		{name: "go/", typeflag: tar.TypeDir, mode: 0755},
+		{name: "go/bin/go", typeflag: tar.TypeReg, mode: 0755, body: "go"},
+		{name: "go/bin/gofmt", typeflag: tar.TypeReg, mode: 0755, body: "gofmt"},
+		{name: "go/bin/README", typeflag: tar.TypeReg, mode: 0644, body: "readme"},
+		{name: "go/VERSION", typeflag: tar.TypeReg, body: "go1.24.3"},
+	})
+
+	dst := filepath.Join(dir, "store", "go", "go1.24.3")
+	// an existing tree is replaced as a whole
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "stale"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InstallTree(archive, dst, 1, false); err != nil {
+		t.Fatalf("InstallTree() failed: %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dst, "VERSION")); err != nil || string(data) != "go1.24.3" {
+		t.Errorf("VERSION = %q, %v, want %q", data, err, "go1.24.3")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "stale")); !os.IsNotExist(err) {
+		t.Errorf("stale file of the previous tree exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dst), ".go1.24.3.new")); !os.IsNotExist(err) {
+		t.Errorf("temporary tree exists: %v", err)
+	}
+
+	bins, err := treeExecutables(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"bin/go", "bin/gofmt"}; !slices.Equal(bins, want) {
+		t.Errorf("treeExecutables() = %v, want %v", bins, want)
+	}
+}
+
+func TestInstallTreeStripAll(t *testing.T) {
+	dir := t.TempDir()
+	archive := writeTarGz(t, dir, []archiveEntry{
+		{name: "go/bin/go", typeflag: tar.TypeReg, mode: 0755, body: "go"},
+	})
+
+	dst := filepath.Join(dir, "tree")
+	if err := InstallTree(archive, dst, 3, false); err != nil {
+		t.Fatalf("InstallTree() failed: %v", err)
+	}
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("InstallTree() unpacked %d entries, want none", len(entries))
+	}
+}
@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestChownGroup(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bin", "tool"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a dangling link must not be followed
+	if err := os.Symlink("missing", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the own groups are allowed for unprivileged users
+	gid := os.Getgid()
+	if err := ChownGroup(dir, gid); err != nil {
+		t.Fatalf("ChownGroup() failed: %v", err)
+	}
+	for _, name := range []string{".", "bin", "bin/tool", "link"} {
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := int(info.Sys().(*syscall.Stat_t).Gid); got != gid {
+			t.Errorf("%s: group = %d, want %d", name, got, gid)
+		}
+	}
+
+	if err := ChownGroup(filepath.Join(dir, "missing"), -1); err != nil {
+		t.Errorf("ChownGroup() with negative gid failed: %v", err)
+	}
+}
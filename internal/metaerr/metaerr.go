@@ -49,3 +49,15 @@ func GetMetadata(err error) []any {
 
 	return data
 }
+
+// Lookup returns the value of the first metadata entry with the given key
+// in the error chain.
+func Lookup(err error, key string) (any, bool) {
+	data := GetMetadata(err)
+	for i := 0; i+1 < len(data); i += 2 {
+		if k, ok := data[i].(string); ok && k == key {
+			return data[i+1], true
+		}
+	}
+	return nil, false
+}
@@ -7,9 +7,22 @@ import (
 type BinaryData struct {
 	Name        string `yaml:"name"`
 	Provider    string `yaml:"provider,omitempty"`
+	Instance    string `yaml:"instance,omitempty"`
 	Version     string `yaml:"version"`
 	DownloadURL string `yaml:"downloadURL"`
+	Filename    string `yaml:"filename,omitempty"`
+	Checksum    string `yaml:"checksum,omitempty"`
 	ExtractPath string `yaml:"extractPath,omitempty"`
+	Region      string `yaml:"region,omitempty"`
+
+	Layout          string   `yaml:"layout,omitempty"`
+	StripComponents int      `yaml:"stripComponents,omitempty"`
+	Bins            []string `yaml:"bins,omitempty"`
+
+	// Mode and Group are taken from the configuration at install time, so
+	// that changing them doesn't require updating the lock file.
+	Mode  string `yaml:"-"`
+	Group string `yaml:"-"`
 }
 
 type Lock struct {
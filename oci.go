@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	_url "net/url"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.cluttr.dev/prebuilt/internal/metaerr"
+)
+
+const (
+	ociMediaTypeImageIndex      = "application/vnd.oci.image.index.v1+json"
+	ociMediaTypeImageManifest   = "application/vnd.oci.image.manifest.v1+json"
+	dockerMediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	dockerMediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// ociAnnotationTitle is the annotation that ORAS uses for file names
+	ociAnnotationTitle = "org.opencontainers.image.title"
+)
+
+var ociDigestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociBackend lists the tags of a repository in an OCI registry as versions
+// and locates the asset in the layers of the tagged manifest.
+//
+// DSNs have the form `oci://registry/repository?asset=title`, where the
+// layer is selected by its title annotation and/or by its media type given
+// with the `mediaType` parameter.
+type ociBackend struct{}
+
+func (b ociBackend) ListVersions(ctx context.Context, client *http.Client, data ProviderData) ([]string, error) {
+	url := b.repositoryURL(data) + "/tags/list?n=1000"
+
+	var tags []string
+	for url != "" {
+		body, header, err := fetch(ctx, client, url)
+		if err != nil {
+			return nil, metaerr.WithMetadata(err, "url", url)
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, fmt.Errorf("unmarshal tags: %w", err)
+		}
+		tags = append(tags, list.Tags...)
+
+		url = nextPageURL(url, header)
+	}
+	return tags, nil
+}
+
+func (b ociBackend) LocateAsset(ctx context.Context, client *http.Client, data ProviderData, release Release) (Asset, error) {
+	repoURL := b.repositoryURL(data)
+
+	manifest, err := b.manifest(ctx, client, repoURL+"/manifests/"+_url.PathEscape(release.Tag))
+	if err != nil {
+		return Asset{}, err
+	}
+	if len(manifest.Manifests) > 0 {
+		desc, err := selectPlatformManifest(manifest.Manifests, runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			return Asset{}, err
+		}
+		manifest, err = b.manifest(ctx, client, repoURL+"/manifests/"+desc.Digest)
+		if err != nil {
+			return Asset{}, err
+		}
+	}
+
+	title, err := renderTemplate(data.Values["asset"], release.templateData(data))
+	if err != nil {
+		return Asset{}, metaerr.WithMetadata(fmt.Errorf("render asset: %w", err), "template", data.Values["asset"])
+	}
+	layer, err := selectLayer(manifest.Layers, title, data.Values["mediaType"])
+	if err != nil {
+		return Asset{}, err
+	}
+	if !ociDigestPattern.MatchString(layer.Digest) {
+		return Asset{}, fmt.Errorf("invalid layer digest: %s", layer.Digest)
+	}
+
+	return Asset{
+		URL:      repoURL + "/blobs/" + layer.Digest,
+		Filename: layer.Annotations[ociAnnotationTitle],
+		Checksum: layer.Digest,
+	}, nil
+}
+
+func (b ociBackend) manifest(ctx context.Context, client *http.Client, url string) (ociManifest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return ociManifest{}, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		ociMediaTypeImageManifest,
+		ociMediaTypeImageIndex,
+		dockerMediaTypeManifest,
+		dockerMediaTypeManifestList,
+	}, ", "))
+
+	body, _, err := fetchRequest(client, req)
+	if err != nil {
+		return ociManifest{}, metaerr.WithMetadata(fmt.Errorf("get manifest: %w", err), "url", url)
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return ociManifest{}, metaerr.WithMetadata(fmt.Errorf("unmarshal manifest: %w", err), "url", url)
+	}
+	return manifest, nil
+}
+
+// repositoryURL returns the distribution API url of the repository.
+// Registries on the loopback interface are accessed via plain http.
+func (b ociBackend) repositoryURL(data ProviderData) string {
+	registry, repo := data.Host, data.Path
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+		if !strings.Contains(repo, "/") {
+			repo = "library/" + repo
+		}
+	}
+
+	baseURL := data.BaseURL
+	if baseURL == "" {
+		baseURL = "https://" + registry
+		if isLoopback(registry) {
+			baseURL = "http://" + registry
+		}
+	}
+	return baseURL + "/v2/" + repo
+}
+
+func isLoopback(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// selectPlatformManifest returns the manifest for the given platform from the
+// manifests of an image index.
+func selectPlatformManifest(manifests []ociDescriptor, os string, arch string) (ociDescriptor, error) {
+	for _, desc := range manifests {
+		if desc.Platform != nil && desc.Platform.OS == os && desc.Platform.Architecture == arch {
+			return desc, nil
+		}
+	}
+	if len(manifests) == 1 && manifests[0].Platform == nil {
+		return manifests[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf("no manifest for platform: %s/%s", os, arch)
+}
+
+// selectLayer returns the first layer with the given title annotation and
+// media type. Empty values match all layers, but the selection must not be
+// ambiguous if neither is given.
+func selectLayer(layers []ociDescriptor, title string, mediaType string) (ociDescriptor, error) {
+	if title == "" && mediaType == "" {
+		if len(layers) != 1 {
+			return ociDescriptor{}, fmt.Errorf("ambiguous layer: manifest has %d layers, use `asset` or `mediaType` to select one", len(layers))
+		}
+		return layers[0], nil
+	}
+
+	for _, layer := range layers {
+		if title != "" && layer.Annotations[ociAnnotationTitle] != title {
+			continue
+		}
+		if mediaType != "" && layer.MediaType != mediaType {
+			continue
+		}
+		return layer, nil
+	}
+	return ociDescriptor{}, metaerr.WithMetadata(errors.New("no matching layer"), "title", title, "mediaType", mediaType)
+}
+
+func newRegistryClient(credentials string, authHosts []string) *http.Client {
+	return &http.Client{
+		Transport: &registryTransport{
+			Transport:   defaultTransport(),
+			credentials: credentials,
+			authHosts:   authHosts,
+		},
+	}
+}
+
+// registryTransport authenticates requests to OCI registries using the token
+// authentication flow of the distribution spec.
+//
+// Credentials of the form `username:password` are used to request tokens,
+// other credentials are sent as bearer token as-is when challenged.
+// Credentials are only sent to the registry, i.e. the host of the original
+// request before any redirects, and to token services on the registry host
+// or one of the authHosts that use https. Other hosts that challenge, e.g.
+// blob storage that a registry redirects to, get anonymous tokens.
+// Tokens are only ever sent to the host that challenged for them.
+type registryTransport struct {
+	*http.Transport
+	credentials string
+	// authHosts are the token service hosts besides the registries
+	// themselves that credentials are sent to.
+	authHosts []string
+
+	mu     sync.Mutex
+	tokens map[string]string // by host
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return t.Transport.RoundTrip(req)
+	}
+
+	if token := t.token(req.URL.Host); token != "" {
+		req = withBearerToken(req, token)
+	}
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge, ok := parseBearerChallenge(resp.Header.Values("WWW-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	registry := originHost(req)
+	token := t.credentials
+	if token == "" || strings.Contains(token, ":") || req.URL.Host != registry {
+		token, err = t.requestToken(req.Context(), registry, challenge)
+		if err != nil {
+			return nil, fmt.Errorf("registry authentication: %w", err)
+		}
+	}
+	t.setToken(req.URL.Host, token)
+
+	return t.Transport.RoundTrip(withBearerToken(req, token))
+}
+
+// trustedRealm reports whether credentials may be sent to the token service
+// at realm on behalf of the registry.
+func (t *registryTransport) trustedRealm(realm *_url.URL, registry string) bool {
+	// plain http only for local registries, like the registry urls
+	if realm.Scheme != "https" && !(realm.Scheme == "http" && isLoopback(realm.Host)) {
+		return false
+	}
+	return strings.EqualFold(realm.Host, registry) || slices.ContainsFunc(t.authHosts, func(host string) bool {
+		return strings.EqualFold(realm.Host, host)
+	})
+}
+
+func (t *registryTransport) token(host string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tokens[host]
+}
+
+func (t *registryTransport) setToken(host string, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tokens == nil {
+		t.tokens = make(map[string]string)
+	}
+	t.tokens[host] = token
+}
+
+// requestToken retrieves a token from the authorization service given by
+// the challenge, anonymously unless the service is trusted by the registry.
+func (t *registryTransport) requestToken(ctx context.Context, registry string, challenge map[string]string) (string, error) {
+	realm, err := _url.Parse(challenge["realm"])
+	if err != nil || (realm.Scheme != "https" && realm.Scheme != "http") {
+		return "", fmt.Errorf("invalid realm: %q", challenge["realm"])
+	}
+	q := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if v, ok := challenge[key]; ok {
+			q.Set(key, v)
+		}
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username, password, ok := strings.Cut(t.credentials, ":"); ok && t.trustedRealm(realm, registry) {
+		req.SetBasicAuth(username, password)
+	} else if ok {
+		slog.Warn("requesting anonymous token from untrusted auth service", "realm", realm.Redacted(), "registry", registry)
+	}
+
+	body, _, err := fetchRequest(&http.Client{Transport: t.Transport}, req)
+	if err != nil {
+		return "", metaerr.WithMetadata(err, "url", realm.String())
+	}
+
+	var resp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return "", fmt.Errorf("unmarshal token: %w", err)
+	}
+	if resp.Token != "" {
+		return resp.Token, nil
+	} else if resp.AccessToken != "" {
+		return resp.AccessToken, nil
+	}
+	return "", errors.New("empty token")
+}
+
+func withBearerToken(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// parseBearerChallenge returns the parameters of the first Bearer challenge
+// in the given WWW-Authenticate header values, e.g.
+//
+//	Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:foo:pull"
+func parseBearerChallenge(headers []string) (map[string]string, bool) {
+	for _, header := range headers {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			continue
+		}
+
+		params := make(map[string]string)
+		for rest = strings.TrimSpace(rest); rest != ""; {
+			var key, value string
+			key, rest, _ = strings.Cut(rest, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+
+			if strings.HasPrefix(rest, `"`) {
+				// quoted values may contain commas
+				end := strings.Index(rest[1:], `"`)
+				if end < 0 {
+					value, rest = rest[1:], ""
+				} else {
+					value, rest = rest[1:end+1], rest[end+2:]
+				}
+				rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+			} else {
+				value, rest, _ = strings.Cut(rest, ",")
+			}
+			params[key] = strings.TrimSpace(value)
+			rest = strings.TrimSpace(rest)
+		}
+		if params["realm"] != "" {
+			return params, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeRegistry serves a single repository like a `registry:2` instance with
+// token authentication, redirecting blob downloads to a separate storage.
+type fakeRegistry struct {
+	srv   *httptest.Server
+	blobs map[string][]byte
+}
+
+func setupFakeRegistry(t *testing.T, repo string, tags []string, files map[string]string) *fakeRegistry {
+	mux, srv := setupServer(t)
+	storageMux, storage := setupServer(t)
+	reg := &fakeRegistry{srv: srv, blobs: make(map[string][]byte)}
+
+	const token = "registry-token"
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") == "Bearer "+token {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="%s/token",service="fake-registry",scope="repository:%s:pull"`, srv.URL, repo,
+		))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	addBlob := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		reg.blobs[digest] = data
+		return digest
+	}
+
+	// artifact manifests with one layer per file, referenced by an index
+	manifests := make(map[string]string)
+	for _, tag := range tags {
+		var layers []ociDescriptor
+		for _, name := range slices.Sorted(maps.Keys(files)) {
+			title := strings.ReplaceAll(name, "{{ .Version }}", tag)
+			content := []byte(fmt.Sprintf(files[name], tag))
+			layers = append(layers, ociDescriptor{
+				MediaType:   "application/vnd.example.file",
+				Digest:      addBlob(content),
+				Size:        int64(len(content)),
+				Annotations: map[string]string{ociAnnotationTitle: title},
+			})
+		}
+		manifest, _ := json.Marshal(ociManifest{MediaType: ociMediaTypeImageManifest, Layers: layers})
+		manifestDigest := addBlob(manifest)
+
+		index, _ := json.Marshal(map[string]any{
+			"mediaType": ociMediaTypeImageIndex,
+			"manifests": []map[string]any{
+				{
+					"mediaType": ociMediaTypeImageManifest,
+					"digest":    manifestDigest,
+					"platform":  map[string]string{"os": runtime.GOOS, "architecture": runtime.GOARCH},
+				},
+			},
+		})
+		manifests[tag] = string(index)
+		manifests[manifestDigest] = string(manifest)
+	}
+
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "robot" || pass != "secret" {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("scope") != "repository:"+repo+":pull" {
+			http.Error(w, "invalid scope", http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+	})
+	mux.HandleFunc("GET /v2/"+repo+"/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		// one tag per page, linked relatively like the distribution API does
+		i := slices.Index(tags, r.URL.Query().Get("last")) + 1
+		if i < len(tags)-1 {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=1&last=%s>; rel="next"`, repo, tags[i]))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"name": repo, "tags": tags[i : i+1]})
+	})
+	mux.HandleFunc("GET /v2/"+repo+"/manifests/{reference}", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		manifest, ok := manifests[r.PathValue("reference")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(manifest))
+	})
+	mux.HandleFunc("GET /v2/"+repo+"/blobs/{digest}", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		http.Redirect(w, r, storage.URL+"/"+r.PathValue("digest"), http.StatusTemporaryRedirect)
+	})
+	storageMux.HandleFunc("GET /{digest}", func(w http.ResponseWriter, r *http.Request) {
+		// like presigned storage urls, which reject additional credentials
+		if r.Header.Get("Authorization") != "" {
+			http.Error(w, "unexpected credentials", http.StatusBadRequest)
+			return
+		}
+		blob, ok := reg.blobs[r.PathValue("digest")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(blob)
+	})
+
+	return reg
+}
+
+func TestOCIProvider(t *testing.T) {
+	reg := setupFakeRegistry(t, "tools/cli", []string{"v1.0.0", "v1.1.0", "latest", "v1.2.0-rc.1"}, map[string]string{
+		"cli_{{ .Version }}_linux":  "cli %s for linux",
+		"cli_{{ .Version }}_darwin": "cli %s for darwin",
+	})
+	t.Setenv("PREBUILT_OCI_TOKEN", "robot:secret")
+
+	providers, err := InitProviders(slices.Clone(builtinProviderSpecs), AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Resolver{Providers: providers}
+
+	host := strings.TrimPrefix(reg.srv.URL, "http://")
+	data, err := r.resolve(context.Background(), BinarySpec{
+		Name:     "cli",
+		Version:  Version{String: ptr("latest")},
+		Provider: ProviderConfig{DSN: ptr("oci://" + host + "/tools/cli?asset=cli_{{ .Version }}_linux")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := []byte("cli v1.1.0 for linux")
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	want := BinaryData{
+		Name:        "cli",
+		Provider:    "oci",
+		Version:     "v1.1.0",
+		DownloadURL: reg.srv.URL + "/v2/tools/cli/blobs/" + digest,
+		Filename:    "cli_v1.1.0_linux",
+		Checksum:    digest,
+	}
+	if diff := cmp.Diff(want, data); diff != "" {
+		t.Fatalf("resolve() mismatch (-want +got):\n%s", diff)
+	}
+
+	path, err := Download(context.Background(), r.Client(data.Provider, data.Instance), data.DownloadURL, t.TempDir(), data.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChecksum(path, data.Checksum); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != string(content) {
+		t.Errorf("downloaded %q, want %q", got, content)
+	}
+}
+
+func Test_selectLayer(t *testing.T) {
+	layers := []ociDescriptor{
+		{MediaType: "application/vnd.example.tar+gzip", Digest: "sha256:aa", Annotations: map[string]string{ociAnnotationTitle: "tool.tar.gz"}},
+		{MediaType: "application/vnd.example.sig", Digest: "sha256:bb", Annotations: map[string]string{ociAnnotationTitle: "tool.sig"}},
+	}
+
+	tests := []struct {
+		testName  string
+		layers    []ociDescriptor
+		title     string
+		mediaType string
+		want      string
+		wantErr   bool
+	}{
+		{testName: "title", layers: layers, title: "tool.sig", want: "sha256:bb"},
+		{testName: "media type", layers: layers, mediaType: "application/vnd.example.tar+gzip", want: "sha256:aa"},
+		{testName: "title and media type mismatch", layers: layers, title: "tool.sig", mediaType: "application/vnd.example.tar+gzip", wantErr: true},
+		{testName: "ambiguous", layers: layers, wantErr: true},
+		{testName: "single layer", layers: layers[:1], want: "sha256:aa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, err := selectLayer(tt.layers, tt.title, tt.mediaType)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("selectLayer() failed: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("selectLayer() succeeded unexpectedly")
+			}
+			if got.Digest != tt.want {
+				t.Errorf("selectLayer() = %s, want %s", got.Digest, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseBearerChallenge(t *testing.T) {
+	tests := []struct {
+		testName string
+		headers  []string
+		want     map[string]string
+	}{
+		{
+			testName: "docker hub",
+			headers:  []string{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"`},
+			want: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/alpine:pull",
+			},
+		},
+		{
+			testName: "comma in quoted value",
+			headers:  []string{`Basic realm="registry"`, `bearer realm="https://auth.example.com", scope="repository:a:pull,push", error=insufficient_scope`},
+			want: map[string]string{
+				"realm": "https://auth.example.com",
+				"scope": "repository:a:pull,push",
+				"error": "insufficient_scope",
+			},
+		},
+		{
+			testName: "basic only",
+			headers:  []string{`Basic realm="registry"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, _ := parseBearerChallenge(tt.headers)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("parseBearerChallenge() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_registryTransport(t *testing.T) {
+	const credentials = "robot:secret"
+
+	// tokenService issues tokens and records the credentials it received
+	type tokenService struct {
+		srv         *httptest.Server
+		credentials chan string
+	}
+	setupTokenService := func(t *testing.T, token string) tokenService {
+		mux, srv := setupServer(t)
+		ts := tokenService{srv: srv, credentials: make(chan string, 10)}
+		mux.HandleFunc("GET /token", func(w http.ResponseWriter, r *http.Request) {
+			user, pass, _ := r.BasicAuth()
+			ts.credentials <- user + ":" + pass
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+		})
+		return ts
+	}
+	// challenge rejects requests without the token, naming the realm
+	challenge := func(w http.ResponseWriter, r *http.Request, realm string, token string) bool {
+		if r.Header.Get("Authorization") == "Bearer "+token {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, realm))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	tests := []struct {
+		testName        string
+		credentials     string
+		trustAuthHost   bool
+		wantCredentials string
+	}{
+		{testName: "untrusted auth host", credentials: credentials, wantCredentials: ":"},
+		{testName: "trusted auth host", credentials: credentials, trustAuthHost: true, wantCredentials: credentials},
+		{testName: "anonymous", wantCredentials: ":"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			auth := setupTokenService(t, "auth-token")
+			mux, srv := setupServer(t)
+			mux.HandleFunc("GET /v2/", func(w http.ResponseWriter, r *http.Request) {
+				if challenge(w, r, auth.srv.URL, "auth-token") {
+					_, _ = w.Write([]byte("ok"))
+				}
+			})
+
+			var authHosts []string
+			if tt.trustAuthHost {
+				authHosts = append(authHosts, strings.TrimPrefix(auth.srv.URL, "http://"))
+			}
+			client := newRegistryClient(tt.credentials, authHosts)
+			if _, _, err := fetch(context.Background(), client, srv.URL+"/v2/"); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-auth.credentials; got != tt.wantCredentials {
+				t.Errorf("token service received credentials %q, want %q", got, tt.wantCredentials)
+			}
+		})
+	}
+
+	t.Run("redirected bearer token", func(t *testing.T) {
+		const rawToken = "raw-token"
+
+		storageAuth := setupTokenService(t, "storage-token")
+		storageMux, storage := setupServer(t)
+		storageMux.HandleFunc("GET /blob", func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.Header.Get("Authorization"), rawToken) {
+				t.Error("storage received the registry token")
+			}
+			if challenge(w, r, storageAuth.srv.URL, "storage-token") {
+				_, _ = w.Write([]byte("blob"))
+			}
+		})
+		mux, srv := setupServer(t)
+		mux.HandleFunc("GET /v2/blob", func(w http.ResponseWriter, r *http.Request) {
+			if challenge(w, r, srv.URL, rawToken) {
+				http.Redirect(w, r, storage.URL+"/blob", http.StatusTemporaryRedirect)
+			}
+		})
+
+		body, _, err := fetch(context.Background(), newRegistryClient(rawToken, nil), srv.URL+"/v2/blob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "blob" {
+			t.Errorf("body = %q, want %q", body, "blob")
+		}
+		if got := <-storageAuth.credentials; got != ":" {
+			t.Errorf("storage token service received credentials %q", got)
+		}
+	})
+}
+
+func Test_registryTransport_trustedRealm(t *testing.T) {
+	rt := registryTransport{authHosts: []string{"auth.docker.io"}}
+
+	tests := []struct {
+		realm    string
+		registry string
+		want     bool
+	}{
+		{realm: "https://ghcr.io/token", registry: "ghcr.io", want: true},
+		{realm: "https://GHCR.io/token", registry: "ghcr.io", want: true},
+		{realm: "https://auth.docker.io/token", registry: "registry-1.docker.io", want: true},
+		{realm: "http://ghcr.io/token", registry: "ghcr.io"},
+		{realm: "https://evil.example.com/token", registry: "ghcr.io"},
+		{realm: "https://ghcr.io.evil.example.com/token", registry: "ghcr.io"},
+		{realm: "https://ghcr.io:8443/token", registry: "ghcr.io"},
+		{realm: "http://localhost:5000/token", registry: "localhost:5000", want: true},
+		{realm: "http://localhost:5001/token", registry: "localhost:5000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.realm, func(t *testing.T) {
+			realm, err := url.Parse(tt.realm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rt.trustedRealm(realm, tt.registry); got != tt.want {
+				t.Errorf("trustedRealm(%q, %q) = %v, want %v", tt.realm, tt.registry, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,50 +1,187 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"maps"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"slices"
 	"sort"
 	"strings"
+	"sync"
 )
 
 type ProviderData struct {
-	Scheme string
-	Host   string
-	Path   string
-	Values map[string]string
+	Scheme  string
+	BaseURL string
+	Host    string
+	Path    string
+	Values  map[string]string
+}
+
+// Project returns the full project path, e.g. `group/subgroup/project`.
+func (d ProviderData) Project() string {
+	return path.Join(d.Host, d.Path)
+}
+
+// Name returns the last element of the project path.
+func (d ProviderData) Name() string {
+	return path.Base(d.Project())
+}
+
+// Value returns the query parameter `key`, or `fallback` if it is not set.
+func (d ProviderData) Value(key string, fallback string) string {
+	if v := d.Values[key]; v != "" {
+		return v
+	}
+	return fallback
+}
+
+const (
+	providerTypeDefault   = ""
+	providerTypeOCI       = "oci"
+	providerTypeS3        = "s3"
+	providerTypeHashicorp = "hashicorp"
+)
+
+// versionLister is implemented by provider backends that list versions
+// themselves instead of using the VersionsURL template.
+type versionLister interface {
+	ListVersions(ctx context.Context, client *http.Client, data ProviderData) ([]string, error)
+}
+
+// assetLocator is implemented by provider backends that locate the asset of
+// a version themselves instead of using the DownloadURL template.
+type assetLocator interface {
+	LocateAsset(ctx context.Context, client *http.Client, data ProviderData, release Release) (Asset, error)
+}
+
+// Asset describes the download of a binary asset.
+type Asset struct {
+	URL      string
+	Filename string
+	Checksum string
+	// ExtractPath is the default path of the binary within the asset.
+	ExtractPath string
+	// Region is the region that download requests are signed for, unless
+	// the URL implies one.
+	Region string
 }
 
 type Provider struct {
 	Spec   ProviderSpec
 	Client *http.Client
+
+	// backend optionally implements versionLister and assetLocator
+	backend any
+
+	// tokens holds the auth tokens of other instances by host
+	tokens map[string]string
+	// tokenEnv is the environment variable that configures the auth token,
+	// if any
+	tokenEnv string
+	mu       sync.Mutex
+	clients  map[string]*http.Client
 }
 
 func NewProvider(spec ProviderSpec) *Provider {
-	client := defaultClient()
-	if spec.AuthToken != "" {
-		client = newAuthedClient(spec.AuthToken)
+	var backend any
+	switch spec.Type {
+	case providerTypeOCI:
+		backend = ociBackend{}
+	case providerTypeS3:
+		backend = s3Backend{}
+	case providerTypeHashicorp:
+		backend = hashicorpBackend{}
 	}
 
 	return &Provider{
-		Spec:   spec,
-		Client: client,
+		Spec:    spec,
+		Client:  newProviderClient(spec, spec.AuthToken),
+		backend: backend,
+	}
+}
+
+// newProviderClient returns a client that authenticates requests with the
+// given token, as required by the provider type.
+func newProviderClient(spec ProviderSpec, token string) *http.Client {
+	switch typ := spec.Type; {
+	case typ == providerTypeOCI:
+		return newRegistryClient(token, spec.AuthHosts)
+	case typ == providerTypeS3:
+		return newS3Client(token)
+	case token != "":
+		return newAuthedClient(token)
 	}
+	return defaultClient()
 }
 
-func InitProviders(specs []ProviderSpec, tokens map[string]string) (map[string]*Provider, error) {
+// InstanceClient returns the client to use for the provider instance at
+// `baseURL`. Instances other than the provider's own one only use the token
+// configured for their host, if any.
+func (p *Provider) InstanceClient(baseURL string) *http.Client {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if baseURL == "" || baseURL == strings.TrimSuffix(p.Spec.BaseURL, "/") {
+		return p.Client
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.clients[baseURL]; ok {
+		return c
+	}
+	client := newProviderClient(p.Spec, p.instanceToken(baseURL))
+	if p.clients == nil {
+		p.clients = make(map[string]*http.Client)
+	}
+	p.clients[baseURL] = client
+	return client
+}
+
+// instanceToken returns the auth token for the provider instance at
+// `baseURL`, see InstanceClient.
+func (p *Provider) instanceToken(baseURL string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if baseURL == "" || baseURL == strings.TrimSuffix(p.Spec.BaseURL, "/") {
+		return p.Spec.AuthToken
+	}
+	return p.tokens[urlHost(baseURL)]
+}
+
+// instanceTokenEnv returns the environment variable that configures the auth
+// token for the provider instance at `baseURL`, if any.
+func (p *Provider) instanceTokenEnv(baseURL string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if baseURL == "" || baseURL == strings.TrimSuffix(p.Spec.BaseURL, "/") {
+		return p.tokenEnv
+	}
+	return ""
+}
+
+func InitProviders(specs []ProviderSpec, tokens AuthTokens) (map[string]*Provider, error) {
+	// the unresolved specs tell the variables that configure the tokens
+	unresolved, err := buildProviderRegistry(slices.Clone(specs))
+	if err != nil {
+		return nil, fmt.Errorf("build provider registry: %w", err)
+	}
+
 	// resolve auth tokens first, so extending providers can inherit them
 	for i := range len(specs) {
 		// try env variables first
-		if _, ok := mayBeEnvVar(specs[i].AuthToken); ok {
-			specs[i].AuthToken = os.Getenv(specs[i].AuthToken)
+		if name, ok := mayBeEnvVar(specs[i].AuthToken); ok {
+			specs[i].AuthToken = os.Getenv(name)
 		}
 		// then try tokens map, given the providers name
 		if specs[i].AuthToken == "" {
-			specs[i].AuthToken = tokens[specs[i].Name]
+			specs[i].AuthToken = tokens.Providers[specs[i].Name]
+		}
+		// then try tokens map, given the providers instance
+		if specs[i].AuthToken == "" && specs[i].BaseURL != "" {
+			specs[i].AuthToken = tokens.Instances[urlHost(specs[i].BaseURL)]
 		}
 	}
 
@@ -56,7 +193,15 @@ func InitProviders(specs []ProviderSpec, tokens map[string]string) (map[string]*
 
 	providers := make(map[string]*Provider, len(registry))
 	for name, spec := range registry {
+		switch spec.Type {
+		case providerTypeDefault, providerTypeOCI, providerTypeS3, providerTypeHashicorp:
+		default:
+			return nil, fmt.Errorf("provider %q has unknown type: %s", name, spec.Type)
+		}
+
 		providers[name] = NewProvider(spec)
+		providers[name].tokens = tokens.Instances
+		providers[name].tokenEnv, _ = mayBeEnvVar(unresolved[name].AuthToken)
 	}
 
 	return providers, nil
@@ -188,17 +333,74 @@ func mergeProviderSpecs(parent, child ProviderSpec) ProviderSpec {
 	spec.Name = child.Name
 	spec.Extends = child.Extends
 
+	if child.Type != "" {
+		spec.Type = child.Type
+	}
+	if child.BaseURL != "" {
+		spec.BaseURL = child.BaseURL
+	}
 	if child.VersionsURL != "" {
 		spec.VersionsURL = child.VersionsURL
 	}
 	if child.VersionsJSONPath != "" {
 		spec.VersionsJSONPath = child.VersionsJSONPath
 	}
+	if child.VersionsFormat != "" {
+		spec.VersionsFormat = child.VersionsFormat
+	}
+	if child.VersionsSelector != "" {
+		spec.VersionsSelector = child.VersionsSelector
+	}
+	if child.VersionsRegex != "" {
+		spec.VersionsRegex = child.VersionsRegex
+	}
+	if child.VersionsOrder != "" {
+		spec.VersionsOrder = child.VersionsOrder
+	}
+	if child.TagsURL != "" {
+		spec.TagsURL = child.TagsURL
+	}
+	if child.TagsJSONPath != "" {
+		spec.TagsJSONPath = child.TagsJSONPath
+	}
+	if child.GitURL != "" {
+		spec.GitURL = child.GitURL
+	}
+	if child.GraphQLURL != "" {
+		spec.GraphQLURL = child.GraphQLURL
+	} else if child.VersionsURL != "" || child.VersionsJSONPath != "" || child.VersionsFormat != "" ||
+		child.VersionsSelector != "" || child.VersionsRegex != "" || child.ReleaseFields != (ReleaseFields{}) {
+		// the parent's GraphQL query doesn't match the child's versions
+		spec.GraphQLURL = ""
+	}
+	if child.ReleaseFields.Version != "" {
+		spec.ReleaseFields.Version = child.ReleaseFields.Version
+	}
+	if child.ReleaseFields.Prerelease != "" {
+		spec.ReleaseFields.Prerelease = child.ReleaseFields.Prerelease
+	}
+	if child.ReleaseFields.Draft != "" {
+		spec.ReleaseFields.Draft = child.ReleaseFields.Draft
+	}
+	if child.ReleaseFields.PublishedAt != "" {
+		spec.ReleaseFields.PublishedAt = child.ReleaseFields.PublishedAt
+	}
+	if child.ReleaseFields.Assets != "" {
+		spec.ReleaseFields.Assets = child.ReleaseFields.Assets
+	}
+	if child.VersionPattern != "" {
+		spec.VersionPattern = child.VersionPattern
+	}
 	if child.DownloadURL != "" {
 		spec.DownloadURL = child.DownloadURL
 	}
 
-	if child.AuthToken != "" {
+	if child.AuthHosts != nil {
+		spec.AuthHosts = child.AuthHosts
+	}
+
+	// never send the parent's token to a different instance
+	if child.AuthToken != "" || spec.BaseURL != parent.BaseURL {
 		spec.AuthToken = child.AuthToken
 	}
 
@@ -222,27 +424,137 @@ func parseDSN(dsn string) (ProviderData, error) {
 	}
 
 	return ProviderData{
-		Scheme: u.Scheme,
-		Host:   u.Host,
-		Path:   strings.TrimPrefix(u.Path, "/"),
-		Values: values,
+		Scheme:  u.Scheme,
+		BaseURL: instanceURL(values["instance"]),
+		Host:    u.Host,
+		Path:    strings.TrimPrefix(u.Path, "/"),
+		Values:  values,
 	}, nil
 }
 
+// instanceURL returns the base URL of a provider instance given by its host
+// name or URL.
+func instanceURL(instance string) string {
+	if instance == "" {
+		return ""
+	}
+	if !strings.Contains(instance, "://") {
+		instance = "https://" + instance
+	}
+	return strings.TrimSuffix(instance, "/")
+}
+
+// urlHost returns the host of the given URL, or the URL itself if it can't
+// be parsed.
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
+
+// githubProviderSpec uses the REST API of github.com, or of a GitHub
+// Enterprise Server instance if another base URL is given.
 var githubProviderSpec = ProviderSpec{
 	Name:             "github",
-	VersionsURL:      "https://api.github.com/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/releases?per_page=100",
-	VersionsJSONPath: "$[*].tag_name",
-	DownloadURL:      "https://github.com/{{ .Provider.Host }}/{{ .Provider.Path }}/releases/download/{{ .Version }}/{{ tpl .Provider.Values.asset . }}",
-	AuthToken:        "${PREBUILT_GITHUB_TOKEN}",
+	BaseURL:          "https://github.com",
+	VersionsURL:      `{{ if eq .Provider.BaseURL "https://github.com" }}https://api.github.com{{ else }}{{ .Provider.BaseURL }}/api/v3{{ end }}/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/releases?per_page=100`,
+	GraphQLURL:       `{{ if eq .Provider.BaseURL "https://github.com" }}https://api.github.com/graphql{{ else }}{{ .Provider.BaseURL }}/api/graphql{{ end }}`,
+	TagsURL:          `{{ if eq .Provider.BaseURL "https://github.com" }}https://api.github.com{{ else }}{{ .Provider.BaseURL }}/api/v3{{ end }}/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/tags?per_page=100`,
+	TagsJSONPath:     "$[*].name",
+	GitURL:           "{{ .Provider.BaseURL }}/{{ .Provider.Project }}.git",
+	VersionsJSONPath: "$[*]",
+	ReleaseFields: ReleaseFields{
+		Version:     "$.tag_name",
+		Prerelease:  "$.prerelease",
+		Draft:       "$.draft",
+		PublishedAt: "$.published_at",
+		Assets:      "$.assets[*].name",
+	},
+	DownloadURL: "{{ .Provider.BaseURL }}/{{ .Provider.Host }}/{{ .Provider.Path }}/releases/download/{{ .Version }}/{{ tpl .Provider.Values.asset . }}",
+	AuthToken:   "${PREBUILT_GITHUB_TOKEN}",
 }
 
 var gitlabProviderSpec = ProviderSpec{
 	Name:             "gitlab",
-	VersionsURL:      `https://gitlab.com/api/v4/projects/{{ printf "%s/%s" .Provider.Host .Provider.Path | urlquery }}/releases?per_page=100`,
-	VersionsJSONPath: "$[*].tag_name",
-	DownloadURL:      "https://gitlab.com/{{ .Provider.Host }}/{{ .Provider.Path }}/-/releases/{{ .Version }}/downloads/{{ tpl .Provider.Values.asset . }}",
-	AuthToken:        "${PREBUILT_GITLAB_TOKEN}",
+	BaseURL:          "https://gitlab.com",
+	VersionsURL:      `{{ .Provider.BaseURL }}/api/v4/projects/{{ .Provider.Project | urlquery }}/releases?per_page=100`,
+	VersionsJSONPath: "$[*]",
+	ReleaseFields: ReleaseFields{
+		Version:     "$.tag_name",
+		Draft:       "$.upcoming_release",
+		PublishedAt: "$.released_at",
+		Assets:      "$.assets.links[*].direct_asset_url",
+	},
+	TagsURL:      `{{ .Provider.BaseURL }}/api/v4/projects/{{ .Provider.Project | urlquery }}/repository/tags?per_page=100`,
+	TagsJSONPath: "$[*].name",
+	GitURL:       "{{ .Provider.BaseURL }}/{{ .Provider.Project }}.git",
+	DownloadURL:  "{{ .Provider.BaseURL }}/{{ .Provider.Project }}/-/releases/{{ .Version }}/downloads/{{ tpl .Provider.Values.asset . }}",
+	AuthToken:    "${PREBUILT_GITLAB_TOKEN}",
+}
+
+// gitlabPackagesProviderSpec uses the generic package registry of a GitLab
+// project. The package name defaults to the project name and can be set
+// with the `package` parameter.
+var gitlabPackagesProviderSpec = ProviderSpec{
+	Name:             "gitlab-packages",
+	Extends:          "gitlab",
+	VersionsURL:      `{{ .Provider.BaseURL }}/api/v4/projects/{{ .Provider.Project | urlquery }}/packages?package_type=generic&package_name={{ .Provider.Value "package" .Provider.Name | urlquery }}&status=default&order_by=created_at&sort=desc&per_page=100`,
+	VersionsJSONPath: `$[?(@.name=='{{ .Provider.Value "package" .Provider.Name | jsonpathescape }}')].version`,
+	DownloadURL:      `{{ .Provider.BaseURL }}/api/v4/projects/{{ .Provider.Project | urlquery }}/packages/generic/{{ .Provider.Value "package" .Provider.Name | pathescape }}/{{ .Version | pathescape }}/{{ tpl .Provider.Values.asset . | pathescape }}`,
+}
+
+// giteaProviderSpec uses the releases API of Gitea and Forgejo instances.
+// Self-hosted instances can be configured by extending it with a `baseUrl`.
+var giteaProviderSpec = ProviderSpec{
+	Name:             "gitea",
+	BaseURL:          "https://gitea.com",
+	VersionsURL:      "{{ .Provider.BaseURL }}/api/v1/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/releases?limit=50",
+	VersionsJSONPath: "$[*]",
+	ReleaseFields: ReleaseFields{
+		Version:     "$.tag_name",
+		Prerelease:  "$.prerelease",
+		Draft:       "$.draft",
+		PublishedAt: "$.published_at",
+		Assets:      "$.assets[*].name",
+	},
+	TagsURL:      "{{ .Provider.BaseURL }}/api/v1/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/tags?limit=50",
+	TagsJSONPath: "$[*].name",
+	GitURL:       "{{ .Provider.BaseURL }}/{{ .Provider.Project }}.git",
+	DownloadURL:  "{{ .Provider.BaseURL }}/{{ .Provider.Host }}/{{ .Provider.Path }}/releases/download/{{ .Version }}/{{ tpl .Provider.Values.asset . }}",
+	AuthToken:    "${PREBUILT_GITEA_TOKEN}",
+}
+
+var codebergProviderSpec = ProviderSpec{
+	Name:      "codeberg",
+	Extends:   "gitea",
+	BaseURL:   "https://codeberg.org",
+	AuthToken: "${PREBUILT_CODEBERG_TOKEN}",
+}
+
+// ociProviderSpec retrieves binaries that are distributed as OCI artifacts.
+var ociProviderSpec = ProviderSpec{
+	Name:      "oci",
+	Type:      providerTypeOCI,
+	AuthToken: "${PREBUILT_OCI_TOKEN}",
+	// Docker Hub's token service
+	AuthHosts: []string{"auth.docker.io"},
+}
+
+// s3ProviderSpec retrieves binaries from S3-compatible object storage.
+var s3ProviderSpec = ProviderSpec{
+	Name:      "s3",
+	Type:      providerTypeS3,
+	AuthToken: "${PREBUILT_S3_TOKEN}",
+}
+
+// hashicorpProviderSpec retrieves HashiCorp products like Terraform or Vault
+// from the official releases site.
+var hashicorpProviderSpec = ProviderSpec{
+	Name:    "hashicorp",
+	Type:    providerTypeHashicorp,
+	BaseURL: "https://releases.hashicorp.com",
 }
 
 var httpProviderSpec = ProviderSpec{
@@ -258,6 +570,12 @@ var httpsProviderSpec = ProviderSpec{
 var builtinProviderSpecs = []ProviderSpec{
 	githubProviderSpec,
 	gitlabProviderSpec,
+	gitlabPackagesProviderSpec,
+	giteaProviderSpec,
+	codebergProviderSpec,
+	ociProviderSpec,
+	s3ProviderSpec,
+	hashicorpProviderSpec,
 	httpsProviderSpec,
 	httpProviderSpec,
 }
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"maps"
+	"net/http"
 	"slices"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	"go.cluttr.dev/prebuilt/internal/metaerr"
 )
 
 func Test_resolveProviderData(t *testing.T) {
@@ -29,6 +34,20 @@ func Test_resolveProviderData(t *testing.T) {
 				},
 			},
 		},
+		{
+			testName: "instance",
+			s:        "gitlab://group/project?asset=tool&instance=gitlab.corp.example/",
+			want: ProviderData{
+				Scheme:  "gitlab",
+				BaseURL: "https://gitlab.corp.example",
+				Host:    "group",
+				Path:    "project",
+				Values: map[string]string{
+					"asset":    "tool",
+					"instance": "gitlab.corp.example/",
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.testName, func(t *testing.T) {
@@ -132,6 +151,62 @@ func Test_buildProviderRegistry(t *testing.T) {
 				},
 			},
 		},
+		{
+			testName: "extend other instance",
+			specs: []ProviderSpec{
+				builtinSpec,
+				{
+					Name:    "builtin-selfhosted",
+					Extends: "builtin",
+					BaseURL: "https://git.example.com",
+				},
+			},
+			want: map[string]ProviderSpec{
+				"builtin": builtinSpec,
+				"builtin-selfhosted": {
+					Name:             "builtin-selfhosted",
+					BaseURL:          "https://git.example.com",    // overridden
+					VersionsURL:      builtinSpec.VersionsURL,      // inherited
+					VersionsJSONPath: builtinSpec.VersionsJSONPath, // inherited
+					DownloadURL:      builtinSpec.DownloadURL,      // inherited
+					AuthToken:        "",                           // not inherited
+
+					Extends: "builtin",
+				},
+			},
+		},
+		{
+			testName: "extend graphql",
+			specs: []ProviderSpec{
+				githubProviderSpec,
+				{Name: "github-mirror", Extends: "github", DownloadURL: "https://mirror.example.com/{{ .Version }}"},
+				{Name: "github-regex", Extends: "github", VersionsRegex: `^v(\d+\.\d+)$`},
+				{Name: "github-fields", Extends: "github", ReleaseFields: ReleaseFields{Version: "$.name"}},
+			},
+			want: map[string]ProviderSpec{
+				"github": githubProviderSpec,
+				"github-mirror": func() ProviderSpec {
+					spec := githubProviderSpec // keeps the GraphQLURL
+					spec.Name, spec.Extends = "github-mirror", "github"
+					spec.DownloadURL = "https://mirror.example.com/{{ .Version }}"
+					return spec
+				}(),
+				"github-regex": func() ProviderSpec {
+					spec := githubProviderSpec
+					spec.Name, spec.Extends = "github-regex", "github"
+					spec.VersionsRegex = `^v(\d+\.\d+)$`
+					spec.GraphQLURL = "" // versions differ from the prefetched ones
+					return spec
+				}(),
+				"github-fields": func() ProviderSpec {
+					spec := githubProviderSpec
+					spec.Name, spec.Extends = "github-fields", "github"
+					spec.ReleaseFields.Version = "$.name"
+					spec.GraphQLURL = "" // versions differ from the prefetched ones
+					return spec
+				}(),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -167,3 +242,437 @@ func Test_buildProviderRegistry(t *testing.T) {
 		})
 	}
 }
+
+func TestInitProviders(t *testing.T) {
+	t.Setenv("PREBUILT_GITEA_TOKEN", "gitea-secret")
+	t.Setenv("PREBUILT_CODEBERG_TOKEN", "")
+
+	t.Setenv("CORP_GITHUB_TOKEN", "")
+
+	specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+		Name:    "forgejo",
+		Extends: "gitea",
+	}, ProviderSpec{
+		Name:      "corp-github",
+		Extends:   "github",
+		BaseURL:   "https://github.corp.example",
+		AuthToken: "${CORP_GITHUB_TOKEN}",
+	})
+	providers, err := InitProviders(specs, AuthTokens{Providers: map[string]string{"codeberg": "codeberg-secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		baseURL   string
+		authToken string
+		tokenEnv  string
+	}{
+		{name: "gitea", baseURL: "https://gitea.com", authToken: "gitea-secret", tokenEnv: "PREBUILT_GITEA_TOKEN"},             // env
+		{name: "codeberg", baseURL: "https://codeberg.org", authToken: "codeberg-secret", tokenEnv: "PREBUILT_CODEBERG_TOKEN"}, // auth file
+		{name: "forgejo", baseURL: "https://gitea.com", authToken: "gitea-secret", tokenEnv: "PREBUILT_GITEA_TOKEN"},           // inherited
+		{name: "corp-github", baseURL: "https://github.corp.example", tokenEnv: "CORP_GITHUB_TOKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prov, ok := providers[tt.name]
+			if !ok {
+				t.Fatalf("missing provider: %s", tt.name)
+			}
+			if prov.Spec.BaseURL != tt.baseURL {
+				t.Errorf("BaseURL = %q, want %q", prov.Spec.BaseURL, tt.baseURL)
+			}
+			if prov.Spec.AuthToken != tt.authToken {
+				t.Errorf("AuthToken = %q, want %q", prov.Spec.AuthToken, tt.authToken)
+			}
+			if got := prov.instanceTokenEnv(tt.baseURL); got != tt.tokenEnv {
+				t.Errorf("instanceTokenEnv() = %q, want %q", got, tt.tokenEnv)
+			}
+			if got := prov.instanceTokenEnv("https://other.example"); got != "" {
+				t.Errorf("instanceTokenEnv() of other instance = %q, want none", got)
+			}
+		})
+	}
+}
+
+func TestGiteaProvider(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc(
+		"GET /api/v1/repos/owner/repo/releases",
+		func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			switch r.URL.Query().Get("page") {
+			case "", "1":
+				w.Header().Set("Link", fmt.Sprintf(`<%s%s?limit=50&page=2>; rel="next"`, srv.URL, r.URL.Path))
+				_, _ = w.Write([]byte(`[{"tag_name": "v2.0.0-rc.1", "assets": [{"name": "tool_v2.0.0-rc.1.tar.gz"}]}]`))
+			case "2":
+				_, _ = w.Write([]byte(`[
+					{"tag_name": "v1.2.0", "assets": [{"name": "tool_v1.2.0.tar.gz"}]},
+					{"tag_name": "v1.1.0", "assets": [{"name": "tool_v1.1.0.tar.gz"}]}
+				]`))
+			default:
+				_, _ = w.Write([]byte(`[]`))
+			}
+		},
+	)
+
+	specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+		Name:      "forgejo",
+		Extends:   "gitea",
+		BaseURL:   srv.URL + "/",
+		AuthToken: "secret",
+	})
+	providers, err := InitProviders(specs, AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Resolver{Providers: providers}
+	data, err := r.resolve(context.Background(), BinarySpec{
+		Name:     "tool",
+		Version:  Version{String: ptr("latest")},
+		Provider: ProviderConfig{DSN: ptr("forgejo://owner/repo?asset=tool_{{ .Version }}.tar.gz")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Version != "v1.2.0" {
+		t.Errorf("Version = %q, want %q", data.Version, "v1.2.0")
+	}
+	if want := srv.URL + "/owner/repo/releases/download/v1.2.0/tool_v1.2.0.tar.gz"; data.DownloadURL != want {
+		t.Errorf("DownloadURL = %q, want %q", data.DownloadURL, want)
+	}
+}
+
+func TestGithubProviderReleases(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /api/v3/repos/owner/repo/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[
+			{"tag_name": "v3.0.0", "draft": true, "prerelease": false, "assets": [{"name": "tool_linux"}]},
+			{"tag_name": "v2.1.0", "draft": false, "prerelease": true, "assets": [{"name": "tool_linux"}]},
+			{"tag_name": "v2.0.0-rc.2", "draft": false, "prerelease": true, "assets": [{"name": "tool_linux"}]},
+			{"tag_name": "v2.0.0-beta.1", "draft": false, "prerelease": true, "assets": [{"name": "tool_linux"}]},
+			{"tag_name": "v1.10.0", "draft": false, "prerelease": false, "assets": [{"name": "tool_darwin"}]},
+			{"tag_name": "v1.9.1", "draft": false, "prerelease": false, "assets": []},
+			{"tag_name": "v1.9.0", "draft": false, "prerelease": false, "assets": [{"name": "tool_darwin"}, {"name": "tool_linux"}]}
+		]`))
+	})
+
+	specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+		Name:    "ghe",
+		Extends: "github",
+		BaseURL: srv.URL,
+	})
+	providers, err := InitProviders(specs, AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Resolver{Providers: providers}
+
+	tests := []struct {
+		testName string
+		version  Version
+		want     string
+		wantErr  bool
+	}{
+		{testName: "latest with asset", version: Version{String: ptr("latest")}, want: "v1.9.0"},
+		{testName: "rc channel", version: Version{Spec: &VersionSpec{Channel: "rc"}}, want: "v2.0.0-rc.2"},
+		{testName: "beta channel", version: Version{Spec: &VersionSpec{Channel: "beta"}}, want: "v2.1.0"},
+		{testName: "prereleases", version: Version{Spec: &VersionSpec{IncludePrereleases: true}}, want: "v2.1.0"},
+		{testName: "explicit prerelease", version: Version{String: ptr(">=2.0.0-beta.0, <2.0.0")}, want: "v2.0.0-rc.2"},
+		{testName: "missing asset", version: Version{String: ptr("~1.9.1")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			data, err := r.resolve(context.Background(), BinarySpec{
+				Name:     "tool",
+				Version:  tt.version,
+				Provider: ProviderConfig{DSN: ptr("ghe://owner/repo?asset=tool_linux")},
+			}, nil)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatal(err)
+				}
+				if skipped, _ := metaerr.Lookup(err, "skipped"); !slices.Equal(skipped.([]string), []string{"v1.9.1"}) {
+					t.Errorf("skipped = %v, want %v", skipped, []string{"v1.9.1"})
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("resolve() succeeded unexpectedly")
+			}
+			if data.Version != tt.want {
+				t.Errorf("Version = %q, want %q", data.Version, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubProviderTags(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /api/v3/repos/owner/repo/tags", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// listed by name, across pages
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v3/repos/owner/repo/tags?per_page=100&page=2>; rel="next"`, srv.URL))
+			_, _ = w.Write([]byte(`[{"name": "v2.0.0"}, {"name": "v1.9.0"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"name": "v1.10.0"}, {"name": "v1.1.0"}]`))
+	})
+	mux.HandleFunc("GET /owner/repo.git/info/refs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("service") != "git-upload-pack" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		_, _ = w.Write([]byte(pktLine("# service=git-upload-pack\n") + "0000" +
+			pktLine("1111111111111111111111111111111111111111 HEAD\x00side-band-64k\n") +
+			pktLine("2222222222222222222222222222222222222222 refs/tags/v1.9.0\n") +
+			pktLine("3333333333333333333333333333333333333333 refs/tags/v1.10.1\n") +
+			pktLine("4444444444444444444444444444444444444444 refs/tags/v2.0.0\n") +
+			"0000"))
+	})
+
+	specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+		Name:        "ghe",
+		Extends:     "github",
+		BaseURL:     srv.URL,
+		DownloadURL: "https://cdn.example.com/tool/{{ .Version }}/tool_linux",
+	})
+	providers, err := InitProviders(specs, AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Resolver{Providers: providers}
+
+	tests := []struct {
+		testName string
+		source   string
+		want     string
+		wantErr  bool
+	}{
+		{testName: "tags", source: "tags", want: "v1.10.0"},
+		{testName: "git", source: "git", want: "v1.10.1"},
+		{testName: "unsupported source", source: "commits", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			data, err := r.resolve(context.Background(), BinarySpec{
+				Name:     "tool",
+				Version:  Version{String: ptr("^1")},
+				Provider: ProviderConfig{DSN: ptr("ghe://owner/repo?source=" + tt.source)},
+			}, nil)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("resolve() succeeded unexpectedly")
+			}
+			if data.Version != tt.want {
+				t.Errorf("Version = %q, want %q", data.Version, tt.want)
+			}
+			if want := "https://cdn.example.com/tool/" + tt.want + "/tool_linux"; data.DownloadURL != want {
+				t.Errorf("DownloadURL = %q, want %q", data.DownloadURL, want)
+			}
+		})
+	}
+}
+
+func TestBuiltinProviderURLs(t *testing.T) {
+	providers, err := InitProviders(slices.Clone(builtinProviderSpecs), AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Resolver{Providers: providers}
+
+	tests := []struct {
+		dsn          string
+		wantVersions string
+		wantDownload string
+	}{
+		{
+			dsn:          "github://owner/repo?asset=tool",
+			wantVersions: "https://api.github.com/repos/owner/repo/releases?per_page=100",
+			wantDownload: "https://github.com/owner/repo/releases/download/v1.0.0/tool",
+		},
+		{
+			dsn:          "github://owner/repo?asset=tool&instance=github.corp.example",
+			wantVersions: "https://github.corp.example/api/v3/repos/owner/repo/releases?per_page=100",
+			wantDownload: "https://github.corp.example/owner/repo/releases/download/v1.0.0/tool",
+		},
+		{
+			dsn:          "gitlab://group/project?asset=tool",
+			wantVersions: "https://gitlab.com/api/v4/projects/group%2Fproject/releases?per_page=100",
+			wantDownload: "https://gitlab.com/group/project/-/releases/v1.0.0/downloads/tool",
+		},
+		{
+			dsn:          "gitlab://group/project?asset=tool&instance=http://localhost:8080",
+			wantVersions: "http://localhost:8080/api/v4/projects/group%2Fproject/releases?per_page=100",
+			wantDownload: "http://localhost:8080/group/project/-/releases/v1.0.0/downloads/tool",
+		},
+		{
+			dsn:          "gitlab://group/sub/project?asset=tool",
+			wantVersions: "https://gitlab.com/api/v4/projects/group%2Fsub%2Fproject/releases?per_page=100",
+			wantDownload: "https://gitlab.com/group/sub/project/-/releases/v1.0.0/downloads/tool",
+		},
+		{
+			dsn:          "gitlab-packages://group/sub/project?asset=tool_{{ .Version }}.tar.gz",
+			wantVersions: "https://gitlab.com/api/v4/projects/group%2Fsub%2Fproject/packages?package_type=generic&package_name=project&status=default&order_by=created_at&sort=desc&per_page=100",
+			wantDownload: "https://gitlab.com/api/v4/projects/group%2Fsub%2Fproject/packages/generic/project/v1.0.0/tool_v1.0.0.tar.gz",
+		},
+		{
+			dsn:          "gitlab-packages://group/project?package=cli&asset=cli",
+			wantVersions: "https://gitlab.com/api/v4/projects/group%2Fproject/packages?package_type=generic&package_name=cli&status=default&order_by=created_at&sort=desc&per_page=100",
+			wantDownload: "https://gitlab.com/api/v4/projects/group%2Fproject/packages/generic/cli/v1.0.0/cli",
+		},
+		{
+			dsn:          "gitlab-packages://group/project?package=my%20cli&asset=my%20cli",
+			wantVersions: "https://gitlab.com/api/v4/projects/group%2Fproject/packages?package_type=generic&package_name=my+cli&status=default&order_by=created_at&sort=desc&per_page=100",
+			wantDownload: "https://gitlab.com/api/v4/projects/group%2Fproject/packages/generic/my%20cli/v1.0.0/my%20cli",
+		},
+		{
+			dsn:          "codeberg://owner/repo?asset=tool",
+			wantVersions: "https://codeberg.org/api/v1/repos/owner/repo/releases?limit=50",
+			wantDownload: "https://codeberg.org/owner/repo/releases/download/v1.0.0/tool",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			prov, data, err := r.resolveProvider(ProviderConfig{DSN: ptr(tt.dsn)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			versionsURL, err := renderTemplate(prov.Spec.VersionsURL, map[string]any{"Provider": data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if versionsURL != tt.wantVersions {
+				t.Errorf("versions url = %q, want %q", versionsURL, tt.wantVersions)
+			}
+
+			downloadURL, err := renderTemplate(prov.Spec.DownloadURL, map[string]any{"Provider": data, "Version": "v1.0.0"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if downloadURL != tt.wantDownload {
+				t.Errorf("download url = %q, want %q", downloadURL, tt.wantDownload)
+			}
+		})
+	}
+}
+
+func TestProviderInstanceToken(t *testing.T) {
+	t.Setenv("PREBUILT_GITLAB_TOKEN", "gitlab-secret")
+
+	mux, srv := setupServer(t)
+	mux.HandleFunc(
+		"GET /api/v4/projects/{project}/releases",
+		func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Authorization"); got != "Bearer corp-secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[{"tag_name": "v1.0.0", "assets": {"links": [{"direct_asset_url": "https://gitlab.corp.example/group/project/-/releases/v1.0.0/downloads/tool"}]}}]`))
+		},
+	)
+
+	providers, err := InitProviders(slices.Clone(builtinProviderSpecs), AuthTokens{
+		Instances: map[string]string{strings.TrimPrefix(srv.URL, "http://"): "corp-secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Resolver{Providers: providers}
+	data, err := r.resolve(context.Background(), BinarySpec{
+		Name:     "tool",
+		Version:  Version{String: ptr("latest")},
+		Provider: ProviderConfig{DSN: ptr("gitlab://group/project?asset=tool&instance=" + srv.URL)},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Instance != srv.URL {
+		t.Errorf("Instance = %q, want %q", data.Instance, srv.URL)
+	}
+
+	if got := r.Client("gitlab", data.Instance); got != r.Client("gitlab", data.Instance) {
+		t.Error("Client() is not reused for the same instance")
+	} else if got == providers["gitlab"].Client {
+		t.Error("Client() uses the default instance client")
+	}
+}
+
+func TestGitlabPackagesProvider(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc(
+		"GET /api/v4/projects/{project}/packages",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.PathValue("project") != "group/sub/project" {
+				http.NotFound(w, r)
+				return
+			}
+			// package names are matched fuzzily
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`[
+				{"name": "cli-plugin", "version": "3.0.0", "package_type": "generic"},
+				{"name": "cli's", "version": "2.0.0", "package_type": "generic"},
+				{"name": "cli", "version": "1.2.0", "package_type": "generic"},
+				{"name": "cli", "version": "1.1.0", "package_type": "generic"}
+			]`))
+		},
+	)
+
+	specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+		Name:    "corp-packages",
+		Extends: "gitlab-packages",
+		BaseURL: srv.URL,
+	})
+	providers, err := InitProviders(specs, AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Resolver{Providers: providers}
+	data, err := r.resolve(context.Background(), BinarySpec{
+		Name:     "cli",
+		Version:  Version{String: ptr("latest")},
+		Provider: ProviderConfig{DSN: ptr("corp-packages://group/sub/project?package=cli&asset=cli_{{ .Version }}")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.Version != "1.2.0" {
+		t.Errorf("Version = %q, want %q", data.Version, "1.2.0")
+	}
+	if want := srv.URL + "/api/v4/projects/group%2Fsub%2Fproject/packages/generic/cli/1.2.0/cli_1.2.0"; data.DownloadURL != want {
+		t.Errorf("DownloadURL = %q, want %q", data.DownloadURL, want)
+	}
+
+	// quotes in package names don't alter the query
+	data, err = r.resolve(context.Background(), BinarySpec{
+		Name:     "cli",
+		Version:  Version{String: ptr("latest")},
+		Provider: ProviderConfig{DSN: ptr("corp-packages://group/sub/project?package=cli's&asset=cli")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Version != "2.0.0" {
+		t.Errorf("Version = %q, want %q", data.Version, "2.0.0")
+	}
+}
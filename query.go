@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AsaiYusuke/jsonpath"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+const (
+	versionsFormatJSON = "json"
+	versionsFormatYAML = "yaml"
+	versionsFormatText = "text"
+	versionsFormatXML  = "xml"
+	versionsFormatHTML = "html"
+	// versionsFormatGit is the ref advertisement of the git smart HTTP
+	// protocol, as listed by `git ls-remote`.
+	versionsFormatGit = "git"
+)
+
+const (
+	versionsOrderNewestFirst = "newest-first"
+	versionsOrderUnsorted    = "unsorted"
+)
+
+// VersionsQuery describes how versions are extracted from the response of a
+// versions endpoint.
+type VersionsQuery struct {
+	// Format is the response format, `json` by default.
+	Format string
+	// JSONPath selects the versions in `json` and `yaml` responses.
+	JSONPath string
+	// Selector selects the versions in `xml` and `html` responses.
+	// For xml, it is an XPath subset like `//versions/version` or `//a/@href`.
+	// For html, it is a CSS-style `tag[attribute]` selector, `a[href]` by
+	// default.
+	Selector string
+	// Regex optionally filters the extracted values. If it has a capture
+	// group named `version`, or else any capture group, the version is taken
+	// from it.
+	Regex string
+	// Fields describe the release objects selected by JSONPath, if it
+	// doesn't select the versions directly.
+	Fields ReleaseFields
+	// Order is the order of the listing, `newest-first` by default. Unsorted
+	// listings are read completely before picking a version.
+	Order string
+}
+
+// ReleaseFields are JSONPaths relative to a release object, e.g. `$.tag_name`,
+// that select its properties.
+type ReleaseFields struct {
+	Version string `yaml:"version"`
+	// Prerelease and Draft select booleans that mark a release as prerelease
+	// or as not yet published, respectively.
+	Prerelease string `yaml:"prerelease"`
+	Draft      string `yaml:"draft"`
+	// PublishedAt selects the RFC 3339 publication time of a release.
+	PublishedAt string `yaml:"publishedAt"`
+	// Assets selects the names or urls of the release's assets.
+	Assets string `yaml:"assets"`
+}
+
+// Versions extracts the versions from the response body.
+func (q VersionsQuery) Versions(body []byte) ([]string, error) {
+	releases, err := q.Releases(body)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(releases))
+	for _, release := range releases {
+		versions = append(versions, release.Tag)
+	}
+	return versions, nil
+}
+
+// Releases extracts the releases from the response body. Only their Tag and
+// the properties given by the query's Fields are set.
+func (q VersionsQuery) Releases(body []byte) ([]Release, error) {
+	var (
+		releases []Release
+		err      error
+	)
+	switch q.Format {
+	case "", versionsFormatJSON:
+		var src any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber() // keep unquoted versions like `1.10` as they are
+		if err := dec.Decode(&src); err != nil {
+			return nil, fmt.Errorf("unmarshal response body: %w", err)
+		}
+		releases, err = retrieveReleases(src, q.JSONPath, q.Fields)
+	case versionsFormatYAML:
+		var src any
+		if src, err = decodeYAML(body); err != nil {
+			return nil, fmt.Errorf("unmarshal response body: %w", err)
+		}
+		releases, err = retrieveReleases(src, q.JSONPath, q.Fields)
+	case versionsFormatText:
+		var lines []string
+		lines, err = textLines(body)
+		releases = tagReleases(lines)
+	case versionsFormatXML:
+		var values []string
+		values, err = selectXML(body, q.Selector)
+		releases = tagReleases(values)
+	case versionsFormatHTML:
+		var values []string
+		values, err = selectHTML(body, q.Selector)
+		releases = tagReleases(values)
+	case versionsFormatGit:
+		var tags []string
+		tags, err = gitTags(body)
+		releases = tagReleases(tags)
+	default:
+		return nil, fmt.Errorf("unsupported versions format: %s", q.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Regex == "" {
+		return releases, nil
+	}
+	return filterReleases(releases, q.Regex)
+}
+
+func tagReleases(tags []string) []Release {
+	releases := make([]Release, 0, len(tags))
+	for _, tag := range tags {
+		releases = append(releases, Release{Tag: tag})
+	}
+	return releases
+}
+
+// filterReleases returns the releases whose tag matches the regular
+// expression, with the tag replaced by the captured version.
+func filterReleases(releases []Release, expr string) ([]Release, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("parse versions regex: %w", err)
+	}
+	group := captureGroup(re)
+
+	var filtered []Release
+	for _, release := range releases {
+		m := re.FindStringSubmatch(release.Tag)
+		if m == nil || m[group] == "" {
+			continue
+		}
+		release.Tag = m[group]
+		filtered = append(filtered, release)
+	}
+	return filtered, nil
+}
+
+// captureGroup returns the index of the capture group named `version`, or
+// else of the first capture group or the whole match.
+func captureGroup(re *regexp.Regexp) int {
+	if i := re.SubexpIndex("version"); i > 0 {
+		return i
+	} else if re.NumSubexp() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// retrieveReleases returns the releases selected by the JSONPath, which
+// selects either the versions or release objects described by `fields`.
+func retrieveReleases(src any, path string, fields ReleaseFields) ([]Release, error) {
+	config := jsonpath.Config{}
+	config.SetAccessorMode()
+
+	results, err := jsonpath.Retrieve(path, src, config)
+	if errors.As(err, &jsonpath.ErrorMemberNotExist{}) { // e.g. an empty page
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var (
+		version, prerelease, draft, publishedAt, assets func(src any) ([]any, error)
+		releases                                        []Release
+	)
+	for _, result := range results {
+		value := result.(jsonpath.Accessor).Get()
+		if _, ok := value.(map[string]any); !ok {
+			if tag := scalarString(value); tag != "" {
+				releases = append(releases, Release{Tag: tag})
+			}
+			continue
+		}
+
+		if version == nil {
+			if fields.Version == "" {
+				return nil, fmt.Errorf("missing release version field")
+			}
+			if version, err = jsonpath.Parse(fields.Version); err != nil {
+				return nil, fmt.Errorf("parse release version field: %w", err)
+			}
+			if prerelease, err = parseReleaseField(fields.Prerelease); err != nil {
+				return nil, fmt.Errorf("parse release prerelease field: %w", err)
+			}
+			if draft, err = parseReleaseField(fields.Draft); err != nil {
+				return nil, fmt.Errorf("parse release draft field: %w", err)
+			}
+			if publishedAt, err = parseReleaseField(fields.PublishedAt); err != nil {
+				return nil, fmt.Errorf("parse release publishedAt field: %w", err)
+			}
+			if assets, err = parseReleaseField(fields.Assets); err != nil {
+				return nil, fmt.Errorf("parse release assets field: %w", err)
+			}
+		}
+
+		release := Release{Tag: scalarString(releaseField(version, value))}
+		if release.Tag == "" {
+			continue
+		}
+		release.Prerelease = releaseField(prerelease, value) == true
+		release.Draft = releaseField(draft, value) == true
+		if published, ok := releaseField(publishedAt, value).(string); ok {
+			release.PublishedAt, _ = time.Parse(time.RFC3339, published)
+		}
+		if assets != nil {
+			release.Assets = []string{} // known to have no assets
+			values, _ := assets(value)
+			for _, v := range values {
+				if name, ok := v.(string); ok {
+					release.Assets = append(release.Assets, name)
+				}
+			}
+		}
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
+
+// parseReleaseField parses the JSONPath of an optional release field.
+func parseReleaseField(path string) (func(src any) ([]any, error), error) {
+	if path == "" {
+		return nil, nil
+	}
+	return jsonpath.Parse(path)
+}
+
+// releaseField returns the first value selected from the release object, or
+// nil if there is none.
+func releaseField(field func(src any) ([]any, error), release any) any {
+	if field == nil {
+		return nil
+	}
+	values, err := field(release)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// scalarString returns the string representation of a version value.
+func scalarString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number: // e.g. unquoted versions
+		return v.String()
+	default:
+		return ""
+	}
+}
+
+// decodeYAML decodes the first document of a YAML body into the values that
+// json.Decoder with UseNumber would produce. Numbers keep their literal form,
+// which converting the YAML to JSON doesn't, e.g. `1.10` would become `1.1`.
+func decodeYAML(body []byte) (any, error) {
+	file, err := parser.ParseBytes(body, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Docs) == 0 || file.Docs[0].Body == nil {
+		return nil, nil
+	}
+	return yamlNodeValue(file.Docs[0].Body, make(map[string]any))
+}
+
+// yamlNodeValue returns the value of a YAML node. Anchored values are
+// recorded in `anchors` to resolve aliases.
+func yamlNodeValue(node ast.Node, anchors map[string]any) (any, error) {
+	switch n := node.(type) {
+	case *ast.MappingNode:
+		return yamlMappingValue(n.Values, anchors)
+	case *ast.MappingValueNode:
+		return yamlMappingValue([]*ast.MappingValueNode{n}, anchors)
+	case *ast.MappingKeyNode:
+		return yamlNodeValue(n.Value, anchors)
+	case *ast.SequenceNode:
+		values := make([]any, 0, len(n.Values))
+		for _, v := range n.Values {
+			value, err := yamlNodeValue(v, anchors)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case *ast.AnchorNode:
+		value, err := yamlNodeValue(n.Value, anchors)
+		if err != nil {
+			return nil, err
+		}
+		anchors[n.Name.String()] = value
+		return value, nil
+	case *ast.AliasNode:
+		value, ok := anchors[n.Value.String()]
+		if !ok {
+			return nil, fmt.Errorf("unknown alias: %s", n.Value)
+		}
+		return value, nil
+	case *ast.TagNode:
+		return yamlNodeValue(n.Value, anchors)
+	case *ast.IntegerNode:
+		return json.Number(fmt.Sprint(n.GetValue())), nil
+	case *ast.FloatNode:
+		return json.Number(n.GetToken().Value), nil
+	case *ast.LiteralNode:
+		return n.Value.GetValue(), nil
+	case *ast.InfinityNode, *ast.NanNode:
+		return n.GetToken().Value, nil
+	case ast.ScalarNode:
+		return n.GetValue(), nil
+	default:
+		return nil, fmt.Errorf("unsupported yaml node: %s", node.Type())
+	}
+}
+
+// yamlMappingValue returns the object of the mapping values. Explicit keys
+// take precedence over the ones merged with `<<`.
+func yamlMappingValue(values []*ast.MappingValueNode, anchors map[string]any) (map[string]any, error) {
+	var (
+		object = make(map[string]any, len(values))
+		merged []map[string]any
+	)
+	for _, mv := range values {
+		value, err := yamlNodeValue(mv.Value, anchors)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := mv.Key.(*ast.MergeKeyNode); ok {
+			switch v := value.(type) {
+			case map[string]any:
+				merged = append(merged, v)
+			case []any:
+				for _, m := range v {
+					if m, ok := m.(map[string]any); ok {
+						merged = append(merged, m)
+					}
+				}
+			}
+			continue
+		}
+		key, err := yamlNodeValue(mv.Key, anchors)
+		if err != nil {
+			return nil, err
+		}
+		object[fmt.Sprint(key)] = value
+	}
+	for _, m := range merged {
+		for k, v := range m {
+			if _, ok := object[k]; !ok {
+				object[k] = v
+			}
+		}
+	}
+	return object, nil
+}
+
+// textLines returns the non-empty lines of a plain text response.
+func textLines(body []byte) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	// no line is longer than the whole body
+	scanner.Buffer(nil, max(len(body)+1, bufio.MaxScanTokenSize))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read lines: %w", err)
+	}
+	return lines, nil
+}
+
+// gitTags returns the tags of a ref advertisement of the git smart HTTP
+// protocol, i.e. the response to `GET <repo>/info/refs?service=git-upload-pack`.
+// The advertisement consists of pkt-lines, each prefixed by its length as
+// four hex digits, that list the refs as `<object id> <ref>`.
+func gitTags(body []byte) ([]string, error) {
+	var (
+		tags []string
+		seen = make(map[string]bool)
+	)
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("invalid pkt-line: %q", body)
+		}
+		n, err := strconv.ParseUint(string(body[:4]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkt-line length: %q", body[:4])
+		}
+		if n == 0 { // flush-pkt
+			body = body[4:]
+			continue
+		}
+		if n < 4 || int(n) > len(body) {
+			return nil, fmt.Errorf("invalid pkt-line length: %d", n)
+		}
+		line := strings.TrimSuffix(string(body[4:n]), "\n")
+		body = body[n:]
+
+		if strings.HasPrefix(line, "# service=") {
+			continue
+		}
+		line, _, _ = strings.Cut(line, "\x00") // capabilities
+		_, ref, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		// annotated tags are also listed peeled, as `<tag>^{}`
+		tag, ok := strings.CutPrefix(strings.TrimSuffix(ref, "^{}"), "refs/tags/")
+		if !ok || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     strings.Builder
+	children []*xmlNode
+}
+
+// selectXML evaluates a small subset of XPath on the document: location
+// paths of element names or `*`, separated by `/` (child) or `//`
+// (descendant), optionally ending in `@attribute` or `text()`.
+// Namespaces are ignored.
+func selectXML(body []byte, selector string) ([]string, error) {
+	if !strings.HasPrefix(selector, "/") {
+		return nil, fmt.Errorf("invalid xml selector: %q", selector)
+	}
+
+	root, err := parseXML(body)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal response body: %w", err)
+	}
+
+	nodes := []*xmlNode{root}
+	for rest := selector; rest != ""; {
+		descendant := strings.HasPrefix(rest, "//")
+		rest = strings.TrimLeft(rest, "/")
+
+		var step string
+		step, rest, _ = strings.Cut(rest, "/")
+		if rest != "" {
+			rest = "/" + rest
+		}
+
+		switch {
+		case step == "":
+			return nil, fmt.Errorf("invalid xml selector: %q", selector)
+		case strings.HasPrefix(step, "@") || step == "text()":
+			if rest != "" {
+				return nil, fmt.Errorf("invalid xml selector: %q", selector)
+			}
+			if descendant {
+				nodes = xmlDescendants(nodes, "*", true)
+			}
+			var values []string
+			for _, node := range nodes {
+				if step == "text()" {
+					values = append(values, strings.TrimSpace(node.text.String()))
+				} else if v, ok := node.attrs[step[1:]]; ok {
+					values = append(values, v)
+				}
+			}
+			return values, nil
+		case descendant:
+			nodes = xmlDescendants(nodes, step, false)
+		default:
+			var children []*xmlNode
+			for _, node := range nodes {
+				for _, child := range node.children {
+					if step == "*" || child.name == step {
+						children = append(children, child)
+					}
+				}
+			}
+			nodes = children
+		}
+	}
+
+	values := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		values = append(values, strings.TrimSpace(node.text.String()))
+	}
+	return values, nil
+}
+
+// xmlDescendants returns all descendants of the nodes with the given name,
+// in document order.
+func xmlDescendants(nodes []*xmlNode, name string, self bool) []*xmlNode {
+	var result []*xmlNode
+	var walk func(node *xmlNode)
+	walk = func(node *xmlNode) {
+		for _, child := range node.children {
+			if name == "*" || child.name == name {
+				result = append(result, child)
+			}
+			walk(child)
+		}
+	}
+	for _, node := range nodes {
+		if self && node.name != "" {
+			result = append(result, node)
+		}
+		walk(node)
+	}
+	return result
+}
+
+// parseXML returns the document node of the xml body.
+func parseXML(body []byte) (*xmlNode, error) {
+	doc := &xmlNode{}
+	stack := []*xmlNode{doc}
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, attr := range t.Attr {
+				node.attrs[attr.Name.Local] = attr.Value
+			}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.text.Write(t)
+		}
+	}
+	return doc, nil
+}
+
+var (
+	htmlSelectorPattern  = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*)\[([a-zA-Z_:][-a-zA-Z0-9_:.]*)\]$`)
+	htmlAttributePattern = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+)
+
+// selectHTML returns the values of the attribute of all elements matching the
+// `tag[attribute]` selector, e.g. the links of a directory listing.
+func selectHTML(body []byte, selector string) ([]string, error) {
+	if selector == "" {
+		selector = "a[href]"
+	}
+	m := htmlSelectorPattern.FindStringSubmatch(selector)
+	if m == nil {
+		return nil, fmt.Errorf("invalid html selector: %q", selector)
+	}
+	tag, attr := strings.ToLower(m[1]), strings.ToLower(m[2])
+
+	tagPattern, err := regexp.Compile(`(?is)<` + regexp.QuoteMeta(tag) + `(\s[^>]*)?>`)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, match := range tagPattern.FindAllSubmatch(body, -1) {
+		for _, a := range htmlAttributePattern.FindAllSubmatch(match[1], -1) {
+			if strings.ToLower(string(a[1])) != attr {
+				continue
+			}
+			value := string(a[2]) + string(a[3]) + string(a[4])
+			values = append(values, html.UnescapeString(value))
+			break
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVersionsQuery_Releases(t *testing.T) {
+	tests := []struct {
+		testName string
+		query    VersionsQuery
+		body     string
+		want     []string
+		// wantReleases is compared instead of want if set
+		wantReleases []Release
+		wantErr      bool
+	}{
+		{
+			testName: "json",
+			query:    VersionsQuery{JSONPath: "$[*].tag_name"},
+			body:     `[{"tag_name": "v1.1.0"}, {"tag_name": "v1.0.0"}]`,
+			want:     []string{"v1.1.0", "v1.0.0"},
+		},
+		{
+			testName: "json release objects",
+			query: VersionsQuery{
+				JSONPath: "$[*]",
+				Fields:   ReleaseFields{Version: "$.tag_name", Prerelease: "$.prerelease", Draft: "$.draft"},
+			},
+			body: `[{"tag_name": "v2.0.0", "draft": true}, {"tag_name": "v1.1.0", "prerelease": true}, {"name": "untagged"}, {"tag_name": "v1.0.0"}]`,
+			wantReleases: []Release{
+				{Tag: "v2.0.0", Draft: true},
+				{Tag: "v1.1.0", Prerelease: true},
+				{Tag: "v1.0.0"},
+			},
+		},
+		{
+			testName: "json release metadata",
+			query: VersionsQuery{
+				JSONPath: "$.releases[*]",
+				Fields:   ReleaseFields{Version: "$.tag", PublishedAt: "$.published", Assets: "$.files[*].name"},
+			},
+			body: `{"releases": [
+				{"tag": "v1.1.0", "published": "2024-06-01T12:00:00Z", "files": []},
+				{"tag": "v1.0.0", "published": "invalid", "files": [{"name": "tool_linux"}, {"name": "tool_darwin"}]}
+			]}`,
+			wantReleases: []Release{
+				{Tag: "v1.1.0", PublishedAt: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), Assets: []string{}},
+				{Tag: "v1.0.0", Assets: []string{"tool_linux", "tool_darwin"}},
+			},
+		},
+		{
+			testName: "json release objects without version field",
+			query:    VersionsQuery{JSONPath: "$[*]"},
+			body:     `[{"tag_name": "v1.0.0"}]`,
+			wantErr:  true,
+		},
+		{
+			testName: "yaml",
+			query:    VersionsQuery{Format: "yaml", JSONPath: "$.entries.chart[*].version"},
+			body:     "apiVersion: v1\nentries:\n  chart:\n    - version: 1.2.3\n    - version: \"2.0.0\"\n    - version: 3\n",
+			want:     []string{"1.2.3", "2.0.0", "3"},
+		},
+		{
+			testName: "yaml unquoted numbers",
+			query:    VersionsQuery{Format: "yaml", JSONPath: "$.versions[*]"},
+			body:     "versions: [1.10, 1.9, 2]\n",
+			want:     []string{"1.10", "1.9", "2"},
+		},
+		{
+			testName: "yaml anchors and filters",
+			query:    VersionsQuery{Format: "yaml", JSONPath: "$.releases[?(@.channel=='stable')].version"},
+			body:     "defaults: &stable\n  channel: stable\nreleases:\n  - <<: *stable\n    version: 1.10\n  - channel: beta\n    version: 1.11\n",
+			want:     []string{"1.10"},
+		},
+		{
+			testName: "json unquoted numbers",
+			query:    VersionsQuery{JSONPath: "$[?(@.major>=1)].version"},
+			body:     `[{"version": 1.10, "major": 1}, {"version": 0.9, "major": 0}]`,
+			want:     []string{"1.10"},
+		},
+		{
+			testName: "text",
+			query:    VersionsQuery{Format: "text"},
+			body:     "v1.31.0\n",
+			want:     []string{"v1.31.0"},
+		},
+		{
+			testName: "text with comments",
+			query:    VersionsQuery{Format: "text"},
+			body:     "# releases\n1.0.0\n\n  1.1.0  \n",
+			want:     []string{"1.0.0", "1.1.0"},
+		},
+		{
+			testName: "text with long lines",
+			query:    VersionsQuery{Format: "text", Regex: `^(\d+\.\d+\.\d+)`},
+			body:     "2.0.0 " + strings.Repeat("x", 100_000) + "\n1.0.0\n",
+			want:     []string{"2.0.0", "1.0.0"},
+		},
+		{
+			testName: "xml elements",
+			query:    VersionsQuery{Format: "xml", Selector: "//versioning/versions/version"},
+			body: `<?xml version="1.0" encoding="UTF-8"?>
+<metadata>
+  <groupId>org.example</groupId>
+  <artifactId>tool</artifactId>
+  <versioning>
+    <latest>2.0.0</latest>
+    <versions>
+      <version>1.0.0</version>
+      <version>2.0.0</version>
+    </versions>
+  </versioning>
+</metadata>`,
+			want: []string{"1.0.0", "2.0.0"},
+		},
+		{
+			testName: "xml with namespace and regex",
+			query:    VersionsQuery{Format: "xml", Selector: "//Contents/Key", Regex: `^tool/(v[^/]+)/tool$`},
+			body: `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Contents><Key>tool/v1.0.0/tool</Key></Contents>
+  <Contents><Key>tool/v1.0.0/tool.sig</Key></Contents>
+  <Contents><Key>tool/v1.1.0/tool</Key></Contents>
+</ListBucketResult>`,
+			want: []string{"v1.0.0", "v1.1.0"},
+		},
+		{
+			testName: "xml attributes",
+			query:    VersionsQuery{Format: "xml", Selector: "/releases/release/@version"},
+			body:     `<releases><release version="1.0"/><release/><release version="1.1"/></releases>`,
+			want:     []string{"1.0", "1.1"},
+		},
+		{
+			testName: "xml descendant attributes",
+			query:    VersionsQuery{Format: "xml", Selector: "//@tag"},
+			body:     `<feed tag="x"><entry tag="v1"/><group><entry tag="v2"/></group></feed>`,
+			want:     []string{"x", "v1", "v2"},
+		},
+		{
+			testName: "xml invalid selector",
+			query:    VersionsQuery{Format: "xml", Selector: "versions/version"},
+			body:     `<versions/>`,
+			wantErr:  true,
+		},
+		{
+			testName: "html directory listing",
+			query:    VersionsQuery{Format: "html", Regex: `^tool-(?P<version>\d+\.\d+\.\d+)\.tar\.gz$`},
+			body: `<html><body><h1>Index of /dist</h1>
+<a href="../">../</a>
+<a href="tool-1.0.0.tar.gz">tool-1.0.0.tar.gz</a>
+<A HREF='tool-1.2.0.tar.gz'>tool-1.2.0.tar.gz</A>
+<a class="file" href=tool-1.1.0.tar.gz>tool-1.1.0.tar.gz</a>
+<a href="tool-1.1.0.tar.gz.asc">tool-1.1.0.tar.gz.asc</a>
+</body></html>`,
+			want: []string{"1.0.0", "1.2.0", "1.1.0"},
+		},
+		{
+			testName: "html selector",
+			query:    VersionsQuery{Format: "html", Selector: "option[value]"},
+			body:     `<select><option value="v2.0">v2.0</option><option value="v1.0&#43;build">v1.0</option></select>`,
+			want:     []string{"v2.0", "v1.0+build"},
+		},
+		{
+			testName: "invalid regex",
+			query:    VersionsQuery{Format: "text", Regex: `(`},
+			body:     "1.0.0",
+			wantErr:  true,
+		},
+		{
+			testName: "git refs",
+			query:    VersionsQuery{Format: "git"},
+			body: pktLine("# service=git-upload-pack\n") + "0000" +
+				pktLine("1111111111111111111111111111111111111111 HEAD\x00multi_ack side-band-64k\n") +
+				pktLine("1111111111111111111111111111111111111111 refs/heads/main\n") +
+				pktLine("2222222222222222222222222222222222222222 refs/tags/v1.0.0\n") +
+				pktLine("3333333333333333333333333333333333333333 refs/tags/v1.1.0\n") +
+				pktLine("1111111111111111111111111111111111111111 refs/tags/v1.1.0^{}\n") +
+				"0000",
+			want: []string{"v1.0.0", "v1.1.0"},
+		},
+		{
+			testName: "git invalid pkt-line",
+			query:    VersionsQuery{Format: "git"},
+			body:     "00ffshort",
+			wantErr:  true,
+		},
+		{
+			testName: "unsupported format",
+			query:    VersionsQuery{Format: "toml"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, gotErr := tt.query.Releases([]byte(tt.body))
+			if gotErr != nil {
+				if !tt.wantErr {
+					t.Fatalf("Releases() failed: %v", gotErr)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("Releases() succeeded unexpectedly")
+			}
+			if tt.wantReleases != nil {
+				if diff := cmp.Diff(tt.wantReleases, got); diff != "" {
+					t.Errorf("Releases() mismatch (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if diff := cmp.Diff(tt.want, tagsOf(got)); diff != "" {
+				t.Errorf("Releases() tags mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// pktLine encodes the line as a pkt-line of the git protocol.
+func pktLine(line string) string {
+	return fmt.Sprintf("%04x%s", len(line)+4, line)
+}
+
+func tagsOf(releases []Release) []string {
+	var tags []string
+	for _, release := range releases {
+		tags = append(tags, release.Tag)
+	}
+	return tags
+}
+
+func TestTextVersionsProvider(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /release/stable.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprintln(w, "v1.31.2")
+	})
+
+	specs := append(slices.Clone(builtinProviderSpecs), ProviderSpec{
+		Name:           "kubernetes",
+		VersionsURL:    srv.URL + "/release/stable.txt",
+		VersionsFormat: "text",
+		DownloadURL:    srv.URL + "/release/{{ .Version }}/bin/linux/amd64/kubectl",
+	})
+	providers, err := InitProviders(specs, AuthTokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Resolver{Providers: providers}
+
+	data, err := r.resolve(context.Background(), BinarySpec{
+		Name:     "kubectl",
+		Version:  Version{String: ptr("latest")},
+		Provider: ProviderConfig{DSN: ptr("kubernetes://kubectl")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := BinaryData{
+		Name:        "kubectl",
+		Provider:    "kubernetes",
+		Version:     "v1.31.2",
+		DownloadURL: srv.URL + "/release/v1.31.2/bin/linux/amd64/kubectl",
+	}
+	if diff := cmp.Diff(want, data); diff != "" {
+		t.Fatalf("resolve() mismatch (-want +got):\n%s", diff)
+	}
+}
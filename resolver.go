@@ -2,75 +2,169 @@ package main
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"golang.org/x/sync/errgroup"
+
 	"go.cluttr.dev/prebuilt/internal/metaerr"
 )
 
+// defaultJobs is the default number of binaries that are processed
+// concurrently.
+const defaultJobs = 8
+
+// Version sources, selected by the `source` DSN parameter.
+const (
+	versionSourceReleases = "releases"
+	versionSourceTags     = "tags"
+	versionSourceGit      = "git"
+)
+
 type Resolver struct {
 	Providers map[string]*Provider
+
+	// Jobs limits the number of binaries that are resolved concurrently.
+	// If it is not positive, defaultJobs is used.
+	Jobs int
+
+	// KeepGoing continues resolving the remaining binaries if one fails.
+	// The failures are reported by a *ResolveError alongside the partial
+	// lock.
+	KeepGoing bool
+
+	// MinAge is the default minimum age of versions, see VersionSpec.
+	MinAge time.Duration
+	// IgnoreMinAge disables the minimum age of all versions.
+	IgnoreMinAge bool
+
+	// Denylist denies versions of all binaries.
+	Denylist Denylist
+	// OnSkip, if set, is called for each release that matches the version
+	// constraints but is skipped for the given reason. It may be called
+	// concurrently.
+	OnSkip func(name string, release Release, reason error)
+
+	// Cache caches the responses of version lookups.
+	Cache ResponseCache
+	// RateLimitWait is the maximum time to wait for an exceeded API rate
+	// limit to reset. Requests fail with a *RateLimitError if the reset takes
+	// longer.
+	RateLimitWait time.Duration
+}
+
+// ResolveFailure describes a binary that could not be resolved.
+type ResolveFailure struct {
+	Name string
+	Err  error
+}
+
+// ResolveError is returned by Resolve in keep-going mode if some binaries
+// could not be resolved.
+type ResolveError struct {
+	Failures []ResolveFailure
+}
+
+func (e *ResolveError) Error() string {
+	names := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		names = append(names, f.Name)
+	}
+	return fmt.Sprintf("failed to resolve %d binaries: %s", len(e.Failures), strings.Join(names, ", "))
 }
 
-func (r *Resolver) Client(name string) *http.Client {
-	if c, ok := r.Providers[name]; ok {
-		return c.Client
+func (e *ResolveError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+// jobs returns the effective concurrency limit.
+func (r *Resolver) jobs() int {
+	if r.Jobs > 0 {
+		return r.Jobs
+	}
+	return defaultJobs
+}
+
+// Client returns the client of the named provider for the given instance.
+func (r *Resolver) Client(name string, instance string) *http.Client {
+	if p, ok := r.Providers[name]; ok {
+		return p.InstanceClient(instance)
 	}
 	return defaultClient()
 }
 
+// Resolve resolves all binaries concurrently.
+// The first error cancels the resolution of all remaining binaries, unless
+// KeepGoing is set. In that case, the lock of all binaries that were
+// resolved is returned together with a *ResolveError.
 func (r *Resolver) Resolve(ctx context.Context, bins []BinarySpec) (Lock, error) {
-	// set up workers
-	type result struct {
-		data BinaryData
-		err  error
-	}
+	var (
+		results  = make([]BinaryData, len(bins))
+		failures = make([]error, len(bins))
+	)
 
-	numBins := len(bins)
-	jobs := make(chan BinarySpec, numBins)
-	results := make(chan result, numBins)
+	g, gctx := errgroup.WithContext(ctx)
+	if r.KeepGoing {
+		g, gctx = new(errgroup.Group), ctx
+	}
+	prefetched := r.prefetchReleases(ctx, bins)
 
-	worker := func(specs <-chan BinarySpec, res chan<- result) {
-		for spec := range specs {
-			data, err := r.resolve(ctx, spec)
+	g.SetLimit(r.jobs())
+	for i, spec := range bins {
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			data, err := r.resolve(gctx, spec, prefetched)
 			if err != nil {
-				res <- result{
-					err: metaerr.WithMetadata(err, "name", spec.Name),
+				err = metaerr.WithMetadata(err, "name", spec.Name)
+				if r.KeepGoing {
+					failures[i] = err
+					return nil
 				}
+				return err
 			}
-			res <- result{
-				data: data,
-			}
-		}
-	}
-
-	const concurrency = 8
-	for range concurrency {
-		go worker(jobs, results)
+			results[i] = data
+			return nil
+		})
 	}
-
-	// fan out jobs
-	for _, spec := range bins {
-		jobs <- spec
+	if err := g.Wait(); err != nil {
+		return Lock{}, err
 	}
-	close(jobs)
 
-	// fan in results
-	var locked []BinaryData
-	for range numBins {
-		res := <-results
-		if res.err != nil {
-			return Lock{}, res.err
+	var (
+		locked = make([]BinaryData, 0, len(bins))
+		failed []ResolveFailure
+	)
+	for i := range bins {
+		if failures[i] != nil {
+			// use the name of the lock entry, which may differ from the spec's
+			name, err := r.BinaryName(bins[i])
+			if err != nil {
+				name = bins[i].Name
+			}
+			failed = append(failed, ResolveFailure{Name: name, Err: failures[i]})
+			continue
 		}
-		locked = append(locked, res.data)
+		locked = append(locked, results[i])
 	}
+
 	sort.SliceStable(locked, func(i, j int) bool {
 		return locked[i].Name < locked[j].Name
 	})
@@ -81,21 +175,76 @@ func (r *Resolver) Resolve(ctx context.Context, bins []BinarySpec) (Lock, error)
 		return Lock{}, err
 	}
 
-	return Lock{
+	lock := Lock{
 		Generated: time.Now().UTC(),
 		Digest:    digest,
 		Binaries:  locked,
-	}, nil
+	}
+	if len(failed) > 0 {
+		return lock, &ResolveError{Failures: failed}
+	}
+	return lock, nil
+}
+
+// Merge adds the entries of `prev` for the given binary names to the lock
+// and updates its digest. Names that have no entry in `prev` are ignored.
+func (r *Resolver) Merge(lock Lock, prev Lock, names []string) (Lock, error) {
+	lock.Binaries = slices.Clone(lock.Binaries)
+	for _, name := range names {
+		if slices.ContainsFunc(lock.Binaries, func(b BinaryData) bool { return b.Name == name }) {
+			continue
+		}
+		i := slices.IndexFunc(prev.Binaries, func(b BinaryData) bool { return b.Name == name })
+		if i < 0 {
+			continue
+		}
+		lock.Binaries = append(lock.Binaries, prev.Binaries[i])
+	}
+
+	sort.SliceStable(lock.Binaries, func(i, j int) bool {
+		return lock.Binaries[i].Name < lock.Binaries[j].Name
+	})
+
+	digest, err := r.hash(lock.Binaries)
+	if err != nil {
+		return Lock{}, err
+	}
+	lock.Digest = digest
+	return lock, nil
+}
+
+// BinaryName returns the name under which the binary is installed.
+func (r *Resolver) BinaryName(bin BinarySpec) (string, error) {
+	prov, data, err := r.resolveProvider(bin.Provider)
+	if err != nil {
+		return "", fmt.Errorf("resolve provider: %w", err)
+	}
+	return binaryName(bin, prov, data)
+}
+
+func binaryName(bin BinarySpec, prov *Provider, data ProviderData) (string, error) {
+	name := getBinName(bin, prov.Spec)
+	if name == "" || name == "." || name == "/" {
+		// providers without download url template, e.g. `hashicorp://terraform`
+		name = data.Name()
+	}
+	if name == "" || name == "." || name == "/" {
+		return "", fmt.Errorf("missing binary name")
+	}
+	return name, nil
 }
 
-func (r *Resolver) resolve(ctx context.Context, bin BinarySpec) (BinaryData, error) {
+func (r *Resolver) resolve(ctx context.Context, bin BinarySpec, prefetched prefetchedReleases) (BinaryData, error) {
 	prov, data, err := r.resolveProvider(bin.Provider)
 	if err != nil {
 		return BinaryData{}, fmt.Errorf("resolve provider: %w", err)
 	}
 
 	// Name
-	name := getBinName(bin, prov.Spec)
+	name, err := binaryName(bin, prov, data)
+	if err != nil {
+		return BinaryData{}, err
+	}
 
 	// Version
 	var versionSpec VersionSpec
@@ -104,47 +253,256 @@ func (r *Resolver) resolve(ctx context.Context, bin BinarySpec) (BinaryData, err
 	} else if bin.Version.Spec != nil {
 		versionSpec = *bin.Version.Spec
 	}
-	versionsUrl, err := renderTemplate(prov.Spec.VersionsURL, map[string]any{
-		"Provider": data,
-	})
-	if err != nil {
-		return BinaryData{}, err
+	if versionSpec.Pattern == "" {
+		versionSpec.Pattern = prov.Spec.VersionPattern
 	}
-	version, err := ResolveVersion(ctx, prov.Client, versionsUrl, prov.Spec.VersionsJSONPath, versionSpec.Constraints, versionSpec.Prefix)
+	client := newRateLimitClient(prov.InstanceClient(data.BaseURL), r.RateLimitWait, prov.instanceTokenEnv(data.BaseURL))
+	release, err := r.resolveVersion(ctx, prov, client, name, data, versionSpec, prefetched)
 	if err != nil {
-		return BinaryData{}, metaerr.WithMetadata(fmt.Errorf("resolve version: %w", err), "url", versionsUrl)
+		return BinaryData{}, err
 	}
 
-	// DownloadURL
-	downloadURL, err := renderTemplate(prov.Spec.DownloadURL, map[string]any{
-		"Provider": data,
-		"Version":  version,
-	})
+	// Asset
+	asset, err := r.locateAsset(ctx, prov, client, data, release)
 	if err != nil {
-		return BinaryData{}, metaerr.WithMetadata(fmt.Errorf("render download url: %w", err), "template", prov.Spec.DownloadURL)
+		return BinaryData{}, err
 	}
 
 	// ExtractPath
 	var extractPath string
 	if bin.ExtractPath != "" {
-		extractPath, err = renderTemplate(bin.ExtractPath, map[string]any{
-			"Provider": data,
-			"Version":  version,
-		})
+		extractPath, err = renderTemplate(bin.ExtractPath, release.templateData(data))
 		if err != nil {
 			return BinaryData{}, metaerr.WithMetadata(fmt.Errorf("render extract path: %w", err), "template", bin.ExtractPath)
 		}
+	} else if bin.Layout != layoutTree {
+		extractPath = asset.ExtractPath
+	}
+
+	// Layout
+	switch bin.Layout {
+	case "", layoutFile, layoutTree:
+	default:
+		return BinaryData{}, fmt.Errorf("invalid layout: %s", bin.Layout)
+	}
+	if bin.StripComponents < 0 {
+		return BinaryData{}, fmt.Errorf("invalid strip components: %d", bin.StripComponents)
+	}
+
+	// Instance, only recorded if it differs from the provider's default
+	var instance string
+	if data.BaseURL != strings.TrimSuffix(prov.Spec.BaseURL, "/") {
+		instance = data.BaseURL
 	}
 
 	return BinaryData{
 		Provider:    prov.Spec.Name,
+		Instance:    instance,
 		Name:        name,
-		Version:     version,
-		DownloadURL: downloadURL,
+		Version:     release.Tag,
+		DownloadURL: asset.URL,
+		Filename:    asset.Filename,
+		Checksum:    asset.Checksum,
 		ExtractPath: extractPath,
+		Region:      asset.Region,
+
+		Layout:          bin.Layout,
+		StripComponents: bin.StripComponents,
+		Bins:            bin.Bins,
 	}, nil
 }
 
+// resolveVersion returns the latest version that matches the spec, either
+// listed by the provider's backend, prefetched or retrieved from its
+// VersionsURL.
+func (r *Resolver) resolveVersion(ctx context.Context, prov *Provider, client *http.Client, name string, data ProviderData, spec VersionSpec, prefetched prefetchedReleases) (_ Release, err error) {
+	client = r.Cache.Client(client)
+
+	minAge := r.MinAge
+	if spec.MinAge != "" {
+		age, err := parseAge(spec.MinAge)
+		if err != nil {
+			return Release{}, fmt.Errorf("parse min age: %w", err)
+		}
+		minAge = age
+	}
+	if r.IgnoreMinAge {
+		minAge = 0
+	}
+	exclusions, err := newVersionExclusions(spec, r.Denylist, name, data)
+	if err != nil {
+		return Release{}, err
+	}
+	unknownAge := false
+	accept := func(release Release) error {
+		err := exclusions.check(release)
+		if err == nil && !hasAsset(release, data) {
+			err = fmt.Errorf("missing asset")
+		}
+		if err == nil {
+			err = checkAge(release, minAge)
+			unknownAge = unknownAge || errors.Is(err, errUnknownPublicationTime)
+		}
+		if err != nil && r.OnSkip != nil {
+			r.OnSkip(name, release, err)
+		}
+		return err
+	}
+	defer func() {
+		if err != nil && unknownAge {
+			err = fmt.Errorf("%w: set `minAge: 0s` for the binary to use versions without publication time, or pass --ignore-min-age", err)
+		}
+	}()
+
+	if lister, ok := prov.backend.(versionLister); ok {
+		versions, err := lister.ListVersions(ctx, client, data)
+		if err != nil {
+			return Release{}, fmt.Errorf("list versions: %w", err)
+		}
+		release, err := FindLatestVersion(versions, spec, accept)
+		if err != nil {
+			return Release{}, fmt.Errorf("resolve version: %w", err)
+		}
+		return release, nil
+	}
+
+	urlTemplate, pathTemplate := prov.Spec.VersionsURL, prov.Spec.VersionsJSONPath
+	query := VersionsQuery{
+		Format:   prov.Spec.VersionsFormat,
+		Selector: prov.Spec.VersionsSelector,
+		Regex:    prov.Spec.VersionsRegex,
+		Fields:   prov.Spec.ReleaseFields,
+		Order:    prov.Spec.VersionsOrder,
+	}
+	switch source := versionSource(data); source {
+	case versionSourceReleases:
+	case versionSourceTags:
+		if prov.Spec.TagsURL == "" {
+			return Release{}, fmt.Errorf("unsupported version source for provider %s: %s", prov.Spec.Name, source)
+		}
+		urlTemplate, pathTemplate = prov.Spec.TagsURL, prov.Spec.TagsJSONPath
+		// tags are usually listed by name rather than by date
+		query = VersionsQuery{Order: versionsOrderUnsorted}
+	case versionSourceGit:
+		if prov.Spec.GitURL == "" {
+			return Release{}, fmt.Errorf("unsupported version source for provider %s: %s", prov.Spec.Name, source)
+		}
+		urlTemplate, pathTemplate = strings.TrimSuffix(prov.Spec.GitURL, "/")+"/info/refs?service=git-upload-pack", ""
+		query = VersionsQuery{Format: versionsFormatGit}
+	default:
+		return Release{}, fmt.Errorf("unsupported version source: %s", source)
+	}
+
+	// a partial list can only be trusted if its order is the same
+	if prov.Spec.GraphQLURL != "" {
+		if list, ok := prefetched.lookup(prov.Spec.Name, data); ok && (list.complete || query.Order != versionsOrderUnsorted) {
+			release, err := FindLatestRelease(list.releases, spec, accept)
+			if err == nil {
+				return release, nil
+			}
+			if list.complete {
+				return Release{}, fmt.Errorf("resolve version: %w", err)
+			}
+			// the match may be on a later page
+		}
+	}
+
+	versionsUrl, err := renderTemplate(urlTemplate, map[string]any{
+		"Provider": data,
+	})
+	if err != nil {
+		return Release{}, err
+	}
+	query.JSONPath, err = renderTemplate(pathTemplate, map[string]any{
+		"Provider": data,
+	})
+	if err != nil {
+		return Release{}, metaerr.WithMetadata(fmt.Errorf("render versions path: %w", err), "template", pathTemplate)
+	}
+	release, err := ResolveVersion(ctx, client, versionsUrl, query, spec, accept)
+	if err != nil {
+		return Release{}, metaerr.WithMetadata(fmt.Errorf("resolve version: %w", err), "url", versionsUrl)
+	}
+	return release, nil
+}
+
+// versionSource returns the source of the binary's versions, given by the
+// `source` DSN parameter.
+func versionSource(data ProviderData) string {
+	return cmp.Or(data.Values["source"], versionSourceReleases)
+}
+
+// hasAsset reports whether the release lists the asset given by the `asset`
+// parameter. Releases are assumed to have it if either is unknown.
+func hasAsset(release Release, data ProviderData) bool {
+	if release.Assets == nil || data.Values["asset"] == "" {
+		return true
+	}
+	asset, err := renderTemplate(data.Values["asset"], release.templateData(data))
+	if err != nil { // reported when locating the asset
+		return true
+	}
+	return release.hasAsset(asset)
+}
+
+// errUnknownPublicationTime is returned by checkAge for releases without
+// publication time, e.g. listed by backends or from tags.
+var errUnknownPublicationTime = errors.New("unknown publication time")
+
+// checkAge returns an error if the release was published less than `minAge`
+// ago. Releases without publication time are rejected as well, since their
+// age can't be checked.
+func checkAge(release Release, minAge time.Duration) error {
+	if minAge <= 0 {
+		return nil
+	}
+	if release.PublishedAt.IsZero() {
+		return errUnknownPublicationTime
+	}
+	if age := time.Since(release.PublishedAt); age < minAge {
+		return fmt.Errorf("published %s ago, less than %s", age.Truncate(time.Minute), minAge)
+	}
+	return nil
+}
+
+// parseAge parses a duration like `72h`, with an additional unit `d` for
+// days, e.g. `7d`.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration: %q", s)
+		}
+		return time.Duration(n * 24 * float64(time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration: %q", s)
+	}
+	return d, nil
+}
+
+// locateAsset returns the download of the given release, either located by
+// the provider's backend or rendered from its DownloadURL.
+func (r *Resolver) locateAsset(ctx context.Context, prov *Provider, client *http.Client, data ProviderData, release Release) (Asset, error) {
+	if locator, ok := prov.backend.(assetLocator); ok {
+		asset, err := locator.LocateAsset(ctx, client, data, release)
+		if err != nil {
+			return Asset{}, fmt.Errorf("locate asset: %w", err)
+		}
+		return asset, nil
+	}
+
+	downloadURL, err := renderTemplate(prov.Spec.DownloadURL, release.templateData(data))
+	if err != nil {
+		return Asset{}, metaerr.WithMetadata(fmt.Errorf("render download url: %w", err), "template", prov.Spec.DownloadURL)
+	}
+	return Asset{URL: downloadURL}, nil
+}
+
 func (r *Resolver) hash(bins []BinaryData) (string, error) {
 	data, err := json.Marshal(bins)
 	if err != nil {
@@ -180,6 +538,10 @@ func (r *Resolver) resolveProvider(cfg ProviderConfig) (*Provider, ProviderData,
 		return nil, ProviderData{}, fmt.Errorf("invalid provider config")
 	}
 
+	if data.BaseURL == "" {
+		data.BaseURL = strings.TrimSuffix(prov.Spec.BaseURL, "/")
+	}
+
 	return prov, data, nil
 }
 
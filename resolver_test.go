@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"go.cluttr.dev/prebuilt/internal/metaerr"
+)
+
+// fakeProvider serves releases and assets of arbitrary repositories.
+// The repositories `broken` and `slow` respond with an error and not at all,
+// respectively.
+type fakeProvider struct {
+	inflight    atomic.Int32
+	maxInflight atomic.Int32
+	waiting     atomic.Int32
+	cancelled   atomic.Int32
+}
+
+func setupFakeProvider(t *testing.T) (*fakeProvider, *Provider) {
+	mux, srv := setupServer(t)
+
+	fake := &fakeProvider{}
+	mux.HandleFunc(
+		"GET /repos/{owner}/{repo}/releases",
+		func(w http.ResponseWriter, r *http.Request) {
+			n := fake.inflight.Add(1)
+			defer fake.inflight.Add(-1)
+			for {
+				m := fake.maxInflight.Load()
+				if n <= m || fake.maxInflight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+
+			switch r.PathValue("repo") {
+			case "broken":
+				// fail only after the slow requests arrived
+				for range 100 {
+					if fake.waiting.Load() > 0 {
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+				http.Error(w, "broken", http.StatusInternalServerError)
+				return
+			case "slow":
+				fake.waiting.Add(1)
+				<-r.Context().Done()
+				fake.cancelled.Add(1)
+				return
+			}
+
+			// give other requests the chance to overlap
+			time.Sleep(time.Millisecond)
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]map[string]string{
+				{"tag_name": "v1.1.0"},
+				{"tag_name": "v1.0.0"},
+			})
+		},
+	)
+	mux.HandleFunc(
+		"GET /download/{owner}/{repo}/{version}/{asset}",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.PathValue("repo") == "broken" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = fmt.Fprintf(w, "%s/%s@%s", r.PathValue("owner"), r.PathValue("repo"), r.PathValue("version"))
+		},
+	)
+
+	prov := &Provider{
+		Spec: ProviderSpec{
+			Name:             "fake",
+			VersionsURL:      srv.URL + "/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/releases",
+			VersionsJSONPath: "$[*].tag_name",
+			DownloadURL:      srv.URL + "/download/{{ .Provider.Host }}/{{ .Provider.Path }}/{{ .Version }}/{{ .Provider.Values.asset }}",
+		},
+		Client: srv.Client(),
+	}
+	return fake, prov
+}
+
+func fakeBinarySpec(name string, repo string) BinarySpec {
+	return BinarySpec{
+		Name:     name,
+		Version:  Version{String: ptr("latest")},
+		Provider: ProviderConfig{DSN: ptr(fmt.Sprintf("fake://owner/%s?asset=tool", repo))},
+	}
+}
+
+func TestResolverResolve(t *testing.T) {
+	fake, prov := setupFakeProvider(t)
+
+	const jobs = 4
+	r := Resolver{
+		Providers: map[string]*Provider{"fake": prov},
+		Jobs:      jobs,
+	}
+
+	var bins []BinarySpec
+	for i := range 32 {
+		bins = append(bins, fakeBinarySpec(fmt.Sprintf("bin%02d", i), fmt.Sprintf("repo%02d", i)))
+	}
+
+	lock, err := r.Resolve(context.Background(), bins)
+	if err != nil {
+		t.Fatalf("Resolve() failed: %v", err)
+	}
+
+	if len(lock.Binaries) != len(bins) {
+		t.Fatalf("Resolve() got %d binaries, want %d", len(lock.Binaries), len(bins))
+	}
+	for i, data := range lock.Binaries {
+		if want := fmt.Sprintf("bin%02d", i); data.Name != want {
+			t.Errorf("Resolve() binary %d = %s, want %s", i, data.Name, want)
+		}
+		if data.Version != "v1.1.0" {
+			t.Errorf("Resolve() %s version = %s, want %s", data.Name, data.Version, "v1.1.0")
+		}
+	}
+	if n := fake.maxInflight.Load(); n > jobs {
+		t.Errorf("Resolve() ran %d jobs concurrently, want at most %d", n, jobs)
+	}
+}
+
+func TestResolverResolveError(t *testing.T) {
+	fake, prov := setupFakeProvider(t)
+
+	r := Resolver{
+		Providers: map[string]*Provider{"fake": prov},
+		Jobs:      4,
+	}
+
+	bins := []BinarySpec{
+		fakeBinarySpec("slow1", "slow"),
+		fakeBinarySpec("slow2", "slow"),
+		fakeBinarySpec("slow3", "slow"),
+		fakeBinarySpec("broken", "broken"),
+		fakeBinarySpec("ok", "ok"),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := r.Resolve(ctx, bins)
+	if err == nil {
+		t.Fatal("Resolve() succeeded unexpectedly")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("Resolve() didn't return before the deadline: %v", err)
+	}
+
+	// the server notices cancelled requests asynchronously
+	for range 100 {
+		if fake.cancelled.Load() == fake.waiting.Load() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fake.cancelled.Load() != fake.waiting.Load() {
+		t.Errorf("Resolve() cancelled %d of %d pending requests", fake.cancelled.Load(), fake.waiting.Load())
+	}
+}
+
+func TestResolverKeepGoing(t *testing.T) {
+	_, prov := setupFakeProvider(t)
+
+	r := Resolver{
+		Providers: map[string]*Provider{"fake": prov},
+		KeepGoing: true,
+	}
+
+	bins := []BinarySpec{
+		fakeBinarySpec("a", "a"),
+		fakeBinarySpec("broken", "broken"),
+		fakeBinarySpec("c", "c"),
+	}
+
+	lock, err := r.Resolve(context.Background(), bins)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("Resolve() error = %v, want *ResolveError", err)
+	}
+	if len(resolveErr.Failures) != 1 || resolveErr.Failures[0].Name != "broken" {
+		t.Fatalf("Resolve() failures = %v", resolveErr.Failures)
+	}
+	if status, _ := metaerr.Lookup(resolveErr.Failures[0].Err, "status"); status != http.StatusInternalServerError {
+		t.Errorf("failure status = %v, want %d", status, http.StatusInternalServerError)
+	}
+
+	var names []string
+	for _, b := range lock.Binaries {
+		names = append(names, b.Name)
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, names); diff != "" {
+		t.Errorf("Resolve() binaries mismatch (-want +got):\n%s", diff)
+	}
+
+	prev := Lock{
+		Binaries: []BinaryData{
+			{Name: "a", Version: "v1.0.0"},
+			{Name: "broken", Version: "v0.9.0"},
+		},
+	}
+	merged, err := r.Merge(lock, prev, []string{"broken", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []BinaryData{lock.Binaries[0], prev.Binaries[1], lock.Binaries[1]}
+	if diff := cmp.Diff(want, merged.Binaries); diff != "" {
+		t.Errorf("Merge() binaries mismatch (-want +got):\n%s", diff)
+	}
+	if digest, _ := r.hash(want); merged.Digest != digest {
+		t.Errorf("Merge() digest = %s, want %s", merged.Digest, digest)
+	}
+}
+
+func TestResolverKeepGoingNames(t *testing.T) {
+	_, prov := setupFakeProvider(t)
+
+	r := Resolver{
+		Providers: map[string]*Provider{"fake": prov},
+		KeepGoing: true,
+	}
+
+	renamed := fakeBinarySpec("broken", "broken")
+	renamed.BinName = "renamed"
+	unnamed := fakeBinarySpec("", "broken")
+	unnamed.ExtractPath = "bin/extracted"
+
+	tests := []struct {
+		testName string
+		spec     BinarySpec
+		want     string
+	}{
+		{testName: "binary name", spec: renamed, want: "renamed"},
+		{testName: "name omitted", spec: unnamed, want: "extracted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			lock, err := r.Resolve(context.Background(), []BinarySpec{tt.spec})
+			var resolveErr *ResolveError
+			if !errors.As(err, &resolveErr) {
+				t.Fatalf("Resolve() error = %v, want *ResolveError", err)
+			}
+			if len(resolveErr.Failures) != 1 || resolveErr.Failures[0].Name != tt.want {
+				t.Fatalf("Resolve() failures = %v, want %s", resolveErr.Failures, tt.want)
+			}
+
+			// the previous entry is kept
+			prev := Lock{Binaries: []BinaryData{{Name: tt.want, Version: "v0.9.0"}}}
+			merged, err := r.Merge(lock, prev, []string{resolveErr.Failures[0].Name})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(prev.Binaries, merged.Binaries); diff != "" {
+				t.Errorf("Merge() binaries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResolverVersionPattern(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /repos/jqlang/jq/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]map[string]string{
+			{"tag_name": "jq-1.8.0rc1"},
+			{"tag_name": "jq-1.7.1"},
+			{"tag_name": "jq-1.6"},
+		})
+	})
+
+	prov := &Provider{
+		Spec: ProviderSpec{
+			Name:             "fake",
+			VersionsURL:      srv.URL + "/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/releases",
+			VersionsJSONPath: "$[*].tag_name",
+			VersionPattern:   `^jq-(\d+\.\d+(?:\.\d+)?)$`,
+			DownloadURL:      srv.URL + "/download/{{ .Tag }}/jq-{{ .VersionCore }}-linux-amd64",
+		},
+		Client: srv.Client(),
+	}
+	r := Resolver{Providers: map[string]*Provider{"fake": prov}}
+
+	tests := []struct {
+		testName string
+		version  Version
+		want     BinaryData
+	}{
+		{
+			testName: "provider pattern",
+			version:  Version{String: ptr("latest")},
+			want: BinaryData{
+				Name:        "jq",
+				Provider:    "fake",
+				Version:     "jq-1.7.1",
+				DownloadURL: srv.URL + "/download/jq-1.7.1/jq-1.7.1-linux-amd64",
+			},
+		},
+		{
+			testName: "constraints",
+			version:  Version{String: ptr("<1.7")},
+			want: BinaryData{
+				Name:        "jq",
+				Provider:    "fake",
+				Version:     "jq-1.6",
+				DownloadURL: srv.URL + "/download/jq-1.6/jq-1.6-linux-amd64",
+			},
+		},
+		{
+			testName: "binary pattern",
+			version:  Version{Spec: &VersionSpec{Pattern: `^jq-(1\.6(?:\.\d+)?)$`, Constraints: "latest"}},
+			want: BinaryData{
+				Name:        "jq",
+				Provider:    "fake",
+				Version:     "jq-1.6",
+				DownloadURL: srv.URL + "/download/jq-1.6/jq-1.6-linux-amd64",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			data, err := r.resolve(context.Background(), BinarySpec{
+				Name:     "jq",
+				Version:  tt.version,
+				Provider: ProviderConfig{DSN: ptr("fake://jqlang/jq")},
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, data); diff != "" {
+				t.Errorf("resolve() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResolverMinAge(t *testing.T) {
+	now := time.Now().UTC()
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /repos/owner/tool/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"tag_name": "v1.3.0", "published_at": now.Add(-2 * time.Hour).Format(time.RFC3339)},
+			{"tag_name": "v1.2.0", "published_at": now.Add(-4 * 24 * time.Hour).Format(time.RFC3339)},
+			{"tag_name": "v1.1.0", "published_at": now.Add(-30 * 24 * time.Hour).Format(time.RFC3339)},
+			{"tag_name": "v1.0.0"},
+		})
+	})
+
+	prov := &Provider{
+		Spec: ProviderSpec{
+			Name:             "fake",
+			VersionsURL:      srv.URL + "/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/releases",
+			VersionsJSONPath: "$[*]",
+			ReleaseFields:    ReleaseFields{Version: "$.tag_name", PublishedAt: "$.published_at"},
+			DownloadURL:      srv.URL + "/download/{{ .Version }}/tool",
+		},
+		Client: srv.Client(),
+	}
+
+	tests := []struct {
+		testName     string
+		minAge       time.Duration
+		ignoreMinAge bool
+		version      Version
+		want         string
+		wantErr      bool
+	}{
+		{testName: "no min age", version: Version{String: ptr("latest")}, want: "v1.3.0"},
+		{testName: "global min age", minAge: 72 * time.Hour, version: Version{String: ptr("latest")}, want: "v1.2.0"},
+		{testName: "binary min age", minAge: 72 * time.Hour, version: Version{Spec: &VersionSpec{MinAge: "7d"}}, want: "v1.1.0"},
+		{testName: "binary without min age", minAge: 72 * time.Hour, version: Version{Spec: &VersionSpec{MinAge: "0s"}}, want: "v1.3.0"},
+		{testName: "ignore min age", minAge: 72 * time.Hour, ignoreMinAge: true, version: Version{String: ptr("latest")}, want: "v1.3.0"},
+		{testName: "unknown publication time", minAge: time.Hour, version: Version{String: ptr("~1.0.0")}, wantErr: true},
+		{testName: "unknown publication time without min age", minAge: time.Hour, version: Version{Spec: &VersionSpec{Constraints: "~1.0.0", MinAge: "0s"}}, want: "v1.0.0"},
+		{testName: "unknown publication time ignoring min age", minAge: time.Hour, ignoreMinAge: true, version: Version{String: ptr("~1.0.0")}, want: "v1.0.0"},
+		{testName: "invalid min age", version: Version{Spec: &VersionSpec{MinAge: "soon"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			r := Resolver{
+				Providers:    map[string]*Provider{"fake": prov},
+				MinAge:       tt.minAge,
+				IgnoreMinAge: tt.ignoreMinAge,
+			}
+			data, err := r.resolve(context.Background(), BinarySpec{
+				Name:     "tool",
+				Version:  tt.version,
+				Provider: ProviderConfig{DSN: ptr("fake://owner/tool")},
+			}, nil)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatal(err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("resolve() succeeded unexpectedly")
+			}
+			if data.Version != tt.want {
+				t.Errorf("Version = %q, want %q", data.Version, tt.want)
+			}
+		})
+	}
+}
+
+// fakeLister lists fixed versions without publication times, like the OCI,
+// S3 and HashiCorp backends do.
+type fakeLister struct {
+	versions []string
+}
+
+func (l fakeLister) ListVersions(context.Context, *http.Client, ProviderData) ([]string, error) {
+	return l.versions, nil
+}
+
+func TestResolverMinAgeLister(t *testing.T) {
+	prov := &Provider{
+		Spec: ProviderSpec{
+			Name:        "lister",
+			DownloadURL: "https://example.com/download/{{ .Version }}/tool",
+		},
+		Client:  http.DefaultClient,
+		backend: fakeLister{versions: []string{"v1.0.0", "v1.1.0"}},
+	}
+
+	tests := []struct {
+		testName     string
+		ignoreMinAge bool
+		version      Version
+		want         string
+	}{
+		// versions without publication time can't be checked
+		{testName: "global min age", version: Version{String: ptr("latest")}},
+		{testName: "binary without min age", version: Version{Spec: &VersionSpec{MinAge: "0s"}}, want: "v1.1.0"},
+		{testName: "ignore min age", ignoreMinAge: true, version: Version{String: ptr("latest")}, want: "v1.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			r := Resolver{
+				Providers:    map[string]*Provider{"lister": prov},
+				MinAge:       72 * time.Hour,
+				IgnoreMinAge: tt.ignoreMinAge,
+			}
+			data, err := r.resolve(context.Background(), BinarySpec{
+				Name:     "tool",
+				Version:  tt.version,
+				Provider: ProviderConfig{DSN: ptr("lister://owner/tool")},
+			}, nil)
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "--ignore-min-age") {
+					t.Fatalf("resolve() error = %v, want hint at --ignore-min-age", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.Version != tt.want {
+				t.Errorf("Version = %q, want %q", data.Version, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolverExclude(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /repos/owner/tool/releases", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"tag_name": "v1.3.0"},
+			{"tag_name": "v1.2.1"},
+			{"tag_name": "v1.2.0"},
+			{"tag_name": "v1.1.0"},
+		})
+	})
+
+	var skipped []string
+	r := Resolver{
+		Providers: map[string]*Provider{"fake": {
+			Spec: ProviderSpec{
+				Name:             "fake",
+				VersionsURL:      srv.URL + "/repos/{{ .Provider.Host }}/{{ .Provider.Path }}/releases",
+				VersionsJSONPath: "$[*]",
+				ReleaseFields:    ReleaseFields{Version: "$.tag_name"},
+				DownloadURL:      srv.URL + "/download/{{ .Version }}/tool",
+			},
+			Client: srv.Client(),
+		}},
+		Denylist: Denylist{Entries: []DenylistEntry{
+			{Provider: "fake://owner/tool", Versions: []string{">=1.2.0, <1.2.2"}, Reason: "broken archive"},
+		}},
+		OnSkip: func(name string, release Release, reason error) {
+			skipped = append(skipped, name+" "+release.Tag+": "+reason.Error())
+		},
+	}
+	data, err := r.resolve(context.Background(), BinarySpec{
+		Name:     "tool",
+		Version:  Version{Spec: &VersionSpec{Exclude: []string{"v1.3.0"}}},
+		Provider: ProviderConfig{DSN: ptr("fake://owner/tool")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Version != "v1.1.0" {
+		t.Errorf("Version = %q, want %q", data.Version, "v1.1.0")
+	}
+	want := []string{
+		"tool v1.3.0: excluded (v1.3.0)",
+		"tool v1.2.1: denied: broken archive (>=1.2.0, <1.2.2)",
+		"tool v1.2.0: denied: broken archive (>=1.2.0, <1.2.2)",
+	}
+	if diff := cmp.Diff(want, skipped); diff != "" {
+		t.Errorf("skipped mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_parseAge(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "72h", want: 72 * time.Hour},
+		{s: "90m", want: 90 * time.Minute},
+		{s: "7d", want: 7 * 24 * time.Hour},
+		{s: "1.5d", want: 36 * time.Hour},
+		{s: "-1h", wantErr: true},
+		{s: "d", wantErr: true},
+		{s: "week", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseAge(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseAge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	_url "net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.cluttr.dev/prebuilt/internal/metaerr"
+)
+
+// emptyPayloadHash is the SHA256 hash of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3RegionPattern matches the region in AWS S3 endpoints, e.g.
+// `bucket.s3.eu-central-1.amazonaws.com` or `s3.us-west-2.amazonaws.com`.
+var s3RegionPattern = regexp.MustCompile(`(?:^|\.)s3[.-]([a-z0-9-]+)\.amazonaws\.com$`)
+
+// s3Backend lists the common prefixes below a bucket prefix as versions and
+// expects the assets to be stored as `<prefix>/<version>/<asset>`.
+//
+// DSNs have the form `s3://bucket/prefix?asset=name`. The `region` and
+// `endpoint` parameters select the AWS region or an S3-compatible service
+// like MinIO, respectively. Requests to other endpoints are signed for the
+// given region, too. The endpoint defaults to `AWS_ENDPOINT_URL_S3` or
+// `AWS_ENDPOINT_URL`.
+type s3Backend struct{}
+
+type s3ListBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+func (b s3Backend) ListVersions(ctx context.Context, client *http.Client, data ProviderData) ([]string, error) {
+	ctx = withSigningRegion(ctx, data.Values["region"])
+	prefix := b.prefix(data)
+
+	var (
+		versions []string
+		token    string
+	)
+	for {
+		q := _url.Values{}
+		q.Set("list-type", "2")
+		q.Set("delimiter", "/")
+		q.Set("prefix", prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		url := b.bucketURL(data) + "/?" + q.Encode()
+
+		body, _, err := fetch(ctx, client, url)
+		if err != nil {
+			return nil, metaerr.WithMetadata(err, "url", url)
+		}
+
+		var result s3ListBucketResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("unmarshal object list: %w", err)
+		}
+		for _, p := range result.CommonPrefixes {
+			version := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			if version != "" {
+				versions = append(versions, version)
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	return versions, nil
+}
+
+func (b s3Backend) LocateAsset(ctx context.Context, client *http.Client, data ProviderData, release Release) (Asset, error) {
+	asset, err := renderTemplate(data.Values["asset"], release.templateData(data))
+	if err != nil {
+		return Asset{}, metaerr.WithMetadata(fmt.Errorf("render asset: %w", err), "template", data.Values["asset"])
+	}
+	if asset == "" {
+		return Asset{}, fmt.Errorf("missing asset")
+	}
+
+	key := b.prefix(data) + release.Tag + "/" + asset
+	return Asset{
+		URL:      b.bucketURL(data) + "/" + awsURIEncode(key, false),
+		Filename: path.Base(asset),
+		Region:   data.Values["region"],
+	}, nil
+}
+
+// prefix returns the key prefix of the versions, including a trailing slash.
+func (b s3Backend) prefix(data ProviderData) string {
+	prefix := strings.Trim(data.Path, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// bucketURL returns the url of the bucket, using virtual-hosted-style urls
+// for AWS and path-style urls for other endpoints.
+func (b s3Backend) bucketURL(data ProviderData) string {
+	bucket := data.Host
+
+	endpoint := instanceURL(data.Values["endpoint"])
+	if endpoint == "" {
+		endpoint = data.BaseURL
+	}
+	if endpoint == "" {
+		endpoint = strings.TrimSuffix(cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")), "/")
+	}
+	if endpoint != "" {
+		return endpoint + "/" + bucket
+	}
+
+	region := cmp.Or(data.Values["region"], os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	if strings.Contains(bucket, ".") { // would not match the wildcard certificate
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s", region, bucket)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+}
+
+// awsCredentials are the credentials used to sign requests.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// parseAWSCredentials returns the credentials given by a token of the form
+// `ACCESS_KEY_ID:SECRET_ACCESS_KEY[:SESSION_TOKEN]`, or else by the
+// `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and `AWS_SESSION_TOKEN`
+// environment variables.
+func parseAWSCredentials(token string) (awsCredentials, bool) {
+	if token != "" {
+		parts := strings.SplitN(token, ":", 3)
+		if len(parts) < 2 {
+			return awsCredentials{}, false
+		}
+		creds := awsCredentials{AccessKeyID: parts[0], SecretAccessKey: parts[1]}
+		if len(parts) == 3 {
+			creds.SessionToken = parts[2]
+		}
+		return creds, true
+	}
+
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	return creds, creds.AccessKeyID != "" && creds.SecretAccessKey != ""
+}
+
+func newS3Client(token string) *http.Client {
+	creds, ok := parseAWSCredentials(token)
+	if !ok { // anonymous access to public buckets
+		return defaultClient()
+	}
+	return &http.Client{
+		Transport: &sigV4Transport{
+			Transport:   defaultTransport(),
+			credentials: creds,
+			region:      cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+			service:     "s3",
+			now:         time.Now,
+		},
+	}
+}
+
+type signingRegionKey struct{}
+
+// withSigningRegion returns a context in which sigV4Transport signs requests
+// for the given region, unless their AWS endpoint implies another one.
+func withSigningRegion(ctx context.Context, region string) context.Context {
+	if region == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, signingRegionKey{}, region)
+}
+
+// sigV4Transport signs requests with AWS Signature Version 4.
+// The region is taken from AWS endpoints, else from the request's context
+// (see withSigningRegion) and defaults to `region` otherwise.
+// Redirects to other hosts are not signed, so that the credentials are only
+// used for the endpoint the request was made to.
+type sigV4Transport struct {
+	*http.Transport
+	credentials awsCredentials
+	region      string
+	service     string
+	now         func() time.Time
+}
+
+func (t *sigV4Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || req.URL.Query().Has("X-Amz-Signature") {
+		return t.Transport.RoundTrip(req)
+	}
+	if !strings.EqualFold(req.URL.Host, originHost(req)) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	region := t.region
+	if r, ok := req.Context().Value(signingRegionKey{}).(string); ok {
+		region = r
+	}
+	if m := s3RegionPattern.FindStringSubmatch(req.URL.Hostname()); m != nil {
+		region = m[1]
+	}
+	t.sign(req, region, t.now().UTC())
+	return t.Transport.RoundTrip(req)
+}
+
+// sign adds the SigV4 authorization header to a request without body.
+func (t *sigV4Transport) sign(req *http.Request, region string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if t.credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.credentials.SessionToken)
+	}
+
+	// make sure the request is sent exactly as it is signed
+	req.URL.RawPath = awsURIEncode(req.URL.Path, false)
+	req.URL.RawQuery = awsCanonicalQuery(req.URL.Query())
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" || name == "content-md5" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, t.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+t.credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, t.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.credentials.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// awsURIEncode encodes all characters except the unreserved ones of
+// RFC 3986, and slashes unless `encodeSlash` is set.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCanonicalQuery returns the query parameters sorted by name and encoded
+// as required for signing.
+func awsCanonicalQuery(q _url.Values) string {
+	params := make([]string, 0, len(q))
+	for name, values := range q {
+		for _, value := range values {
+			params = append(params, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
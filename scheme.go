@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const (
+	versionSchemeSemver  = "semver"
+	versionSchemeCalver  = "calver"
+	versionSchemeLexical = "lexical"
+	versionSchemeNumeric = "numeric"
+)
+
+// orderedVersion is a parsed version of a versioning scheme.
+type orderedVersion interface {
+	// compare returns -1, 0 or +1 if the version is lower than, equal to or
+	// higher than the other version of the same scheme.
+	compare(other orderedVersion) int
+	// hasPrefix reports whether the version starts with the given one, as in
+	// a `2024.*` constraint.
+	hasPrefix(prefix orderedVersion) bool
+}
+
+// versionCheck reports whether a version satisfies a set of constraints.
+type versionCheck func(v orderedVersion) bool
+
+// versionScheme parses versions and constraints of a versioning scheme.
+type versionScheme interface {
+	parse(version string) (orderedVersion, error)
+	// parseConstraints parses the constraints. Schemes that exclude
+	// prereleases from constraints include them if `prereleases` is set.
+	parseConstraints(constraints string, prereleases bool) (versionCheck, error)
+}
+
+// newVersionScheme returns the versioning scheme with the given name,
+// semver by default.
+func newVersionScheme(name string) (versionScheme, error) {
+	switch name {
+	case "", versionSchemeSemver:
+		return semverScheme{}, nil
+	case versionSchemeCalver:
+		return numericScheme{calendar: true}, nil
+	case versionSchemeNumeric:
+		return numericScheme{}, nil
+	case versionSchemeLexical:
+		return lexicalScheme{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported version scheme: %s", name)
+	}
+}
+
+// semverScheme orders semantic versions and supports the constraints of
+// github.com/Masterminds/semver.
+type semverScheme struct{}
+
+type semverVersion struct {
+	*semver.Version
+}
+
+func (v semverVersion) compare(other orderedVersion) int {
+	return v.Compare(other.(semverVersion).Version)
+}
+
+func (v semverVersion) hasPrefix(prefix orderedVersion) bool {
+	return strings.HasPrefix(v.String(), prefix.(semverVersion).String())
+}
+
+func (semverScheme) parse(version string) (orderedVersion, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return semverVersion{v}, nil
+}
+
+func (semverScheme) parseConstraints(constraints string, prereleases bool) (versionCheck, error) {
+	c, err := semver.NewConstraint(constraints)
+	if err != nil {
+		return nil, err
+	}
+	c.IncludePrerelease = prereleases
+	return func(v orderedVersion) bool {
+		return c.Check(v.(semverVersion).Version)
+	}, nil
+}
+
+// numericScheme orders versions by their numbers, e.g. `22.04.3` or
+// `build-1234`. With `calendar` set, versions must start with a year, like
+// `2024.05.27` or `20240601-abcdef`, and only the leading date components are
+// compared. Compact dates like `20240601` are split into year, month and day.
+type numericScheme struct {
+	calendar bool
+}
+
+type numericVersion []int
+
+func (v numericVersion) compare(other orderedVersion) int {
+	o := other.(numericVersion)
+	for i := range max(len(v), len(o)) {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(o) {
+			b = o[i]
+		}
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v numericVersion) hasPrefix(prefix orderedVersion) bool {
+	p := prefix.(numericVersion)
+	return len(p) <= len(v) && slices.Equal(v[:len(p)], p)
+}
+
+func (s numericScheme) parse(version string) (orderedVersion, error) {
+	if s.calendar {
+		return parseCalendarVersion(version)
+	}
+
+	var v numericVersion
+	fields := strings.FieldsFunc(version, func(r rune) bool { return !unicode.IsDigit(r) })
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid numeric version: %s", version)
+		}
+		v = append(v, n)
+	}
+	if len(v) == 0 {
+		return nil, fmt.Errorf("invalid numeric version: %s", version)
+	}
+	return v, nil
+}
+
+func parseCalendarVersion(version string) (numericVersion, error) {
+	fields := strings.FieldsFunc(strings.TrimPrefix(version, "v"), func(r rune) bool {
+		return r == '.' || r == '-' || r == '_'
+	})
+
+	var v numericVersion
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			break // e.g. a commit hash or build suffix
+		}
+		if i == 0 {
+			switch len(field) {
+			case 4: // YYYY
+			case 6: // YYYYMM
+				v = append(v, n/100, n%100)
+				continue
+			case 8: // YYYYMMDD
+				v = append(v, n/10000, n/100%100, n%100)
+				continue
+			default:
+				return nil, fmt.Errorf("invalid calendar version: %s", version)
+			}
+		}
+		v = append(v, n)
+	}
+	if len(v) == 0 {
+		return nil, fmt.Errorf("invalid calendar version: %s", version)
+	}
+	return v, nil
+}
+
+func (s numericScheme) parseConstraints(constraints string, _ bool) (versionCheck, error) {
+	return parseConstraints(constraints, s.parse)
+}
+
+// lexicalScheme orders versions as plain strings.
+type lexicalScheme struct{}
+
+type lexicalVersion string
+
+func (v lexicalVersion) compare(other orderedVersion) int {
+	return strings.Compare(string(v), string(other.(lexicalVersion)))
+}
+
+func (v lexicalVersion) hasPrefix(prefix orderedVersion) bool {
+	return strings.HasPrefix(string(v), string(prefix.(lexicalVersion)))
+}
+
+func (lexicalScheme) parse(version string) (orderedVersion, error) {
+	if version == "" {
+		return nil, fmt.Errorf("empty version")
+	}
+	return lexicalVersion(version), nil
+}
+
+func (s lexicalScheme) parseConstraints(constraints string, _ bool) (versionCheck, error) {
+	return parseConstraints(constraints, s.parse)
+}
+
+var constraintPattern = regexp.MustCompile(`^(!=|>=|<=|==|=|>|<)?\s*([^\s,<>=!|]+)`)
+
+// parseConstraints parses constraints of the form `>=2024.01, <2025` for
+// schemes other than semver. Constraints separated by commas or spaces must
+// all be satisfied, groups separated by `||` alternatively. The supported
+// operators are `=`, `!=`, `>`, `>=`, `<` and `<=`; versions ending in `*`
+// match all versions with the given prefix.
+func parseConstraints(constraints string, parse func(string) (orderedVersion, error)) (versionCheck, error) {
+	var groups [][]versionCheck
+	for _, group := range strings.Split(constraints, "||") {
+		var checks []versionCheck
+		for rest := strings.TrimSpace(group); rest != ""; rest = strings.TrimLeft(rest, ", ") {
+			m := constraintPattern.FindStringSubmatch(rest)
+			if m == nil {
+				return nil, fmt.Errorf("invalid constraint: %q", rest)
+			}
+			rest = rest[len(m[0]):]
+
+			check, err := parseConstraint(m[1], m[2], parse)
+			if err != nil {
+				return nil, err
+			}
+			checks = append(checks, check)
+		}
+		if len(checks) == 0 {
+			return nil, fmt.Errorf("invalid constraints: %q", constraints)
+		}
+		groups = append(groups, checks)
+	}
+
+	return func(v orderedVersion) bool {
+		for _, checks := range groups {
+			if !slices.ContainsFunc(checks, func(check versionCheck) bool { return !check(v) }) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseConstraint(op string, version string, parse func(string) (orderedVersion, error)) (versionCheck, error) {
+	if version == "*" {
+		if op != "" && op != "=" && op != "==" {
+			return nil, fmt.Errorf("invalid constraint: %s%s", op, version)
+		}
+		return func(orderedVersion) bool { return true }, nil
+	}
+
+	if prefix, ok := strings.CutSuffix(version, "*"); ok {
+		p, err := parse(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint: %w", err)
+		}
+		switch op {
+		case "", "=", "==":
+			return func(v orderedVersion) bool { return v.hasPrefix(p) }, nil
+		case "!=":
+			return func(v orderedVersion) bool { return !v.hasPrefix(p) }, nil
+		default:
+			return nil, fmt.Errorf("invalid constraint: %s%s", op, version)
+		}
+	}
+
+	c, err := parse(version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint: %w", err)
+	}
+	switch op {
+	case "", "=", "==":
+		return func(v orderedVersion) bool { return v.compare(c) == 0 }, nil
+	case "!=":
+		return func(v orderedVersion) bool { return v.compare(c) != 0 }, nil
+	case ">":
+		return func(v orderedVersion) bool { return v.compare(c) > 0 }, nil
+	case ">=":
+		return func(v orderedVersion) bool { return v.compare(c) >= 0 }, nil
+	case "<":
+		return func(v orderedVersion) bool { return v.compare(c) < 0 }, nil
+	case "<=":
+		return func(v orderedVersion) bool { return v.compare(c) <= 0 }, nil
+	default:
+		return nil, fmt.Errorf("invalid constraint operator: %s", op)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFindLatestVersionScheme(t *testing.T) {
+	tests := []struct {
+		testName    string
+		scheme      string
+		versions    []string
+		constraints string
+		want        string
+		wantErr     bool
+	}{
+		{
+			testName: "calver",
+			scheme:   "calver",
+			versions: []string{"2024.05.27", "2024.11.04", "2023.12.30", "2024.10.22.232829"},
+			want:     "2024.11.04",
+		},
+		{
+			testName:    "calver range",
+			scheme:      "calver",
+			versions:    []string{"2024.05.27", "2024.11.04", "2023.12.30", "2025.01.15"},
+			constraints: ">=2024.06, <2025",
+			want:        "2024.11.04",
+		},
+		{
+			testName:    "calver prefix",
+			scheme:      "calver",
+			versions:    []string{"2024.05.27", "2024.05.30", "2024.06.01"},
+			constraints: "2024.05.*",
+			want:        "2024.05.30",
+		},
+		{
+			testName: "calver date stamps",
+			scheme:   "calver",
+			versions: []string{"20240601-abcdef", "20240515-123456", "2024.06.02", "nightly"},
+			want:     "2024.06.02",
+		},
+		{
+			testName:    "calver exclude",
+			scheme:      "calver",
+			versions:    []string{"20240601-abcdef", "20240515-123456"},
+			constraints: "!=20240601",
+			want:        "20240515-123456",
+		},
+		{
+			testName: "numeric",
+			scheme:   "numeric",
+			versions: []string{"22.04.3", "24.04", "23.10", "1.2.3-0ubuntu1"},
+			want:     "24.04",
+		},
+		{
+			testName:    "numeric alternatives",
+			scheme:      "numeric",
+			versions:    []string{"build-98", "build-120", "build-101"},
+			constraints: "<100 || =101",
+			want:        "build-101",
+		},
+		{
+			testName: "lexical",
+			scheme:   "lexical",
+			versions: []string{"alpha", "gamma", "beta"},
+			want:     "gamma",
+		},
+		{
+			testName:    "lexical constraints",
+			scheme:      "lexical",
+			versions:    []string{"r1-a", "r2-a", "r2-b", "r3-a"},
+			constraints: "r2-*",
+			want:        "r2-b",
+		},
+		{
+			testName:    "semver",
+			scheme:      "semver",
+			versions:    []string{"v1.10.0", "v1.9.0", "2024.05.27"},
+			constraints: "^1",
+			want:        "v1.10.0",
+		},
+		{
+			testName:    "unsupported operator",
+			scheme:      "calver",
+			versions:    []string{"2024.05.27"},
+			constraints: "~2024.05",
+			wantErr:     true,
+		},
+		{
+			testName:    "invalid constraint version",
+			scheme:      "calver",
+			versions:    []string{"2024.05.27"},
+			constraints: ">=24.05",
+			wantErr:     true,
+		},
+		{
+			testName: "unsupported scheme",
+			scheme:   "pep440",
+			versions: []string{"1.0"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, gotErr := FindLatestVersion(tt.versions, VersionSpec{Scheme: tt.scheme, Constraints: tt.constraints}, nil)
+			if gotErr != nil {
+				if !tt.wantErr {
+					t.Fatalf("FindLatestVersion() failed: %v", gotErr)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("FindLatestVersion() succeeded unexpectedly")
+			}
+			if got.Tag != tt.want {
+				t.Errorf("FindLatestVersion() = %v, want %v", got.Tag, tt.want)
+			}
+		})
+	}
+}
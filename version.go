@@ -1,203 +1,475 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"sort"
+	_url "net/url"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
-	"github.com/AsaiYusuke/jsonpath"
-	"github.com/Masterminds/semver/v3"
 	"go.cluttr.dev/prebuilt/internal/metaerr"
 )
 
-// ResolveVersion returns the latest version that matches the given spec.
-// It queries the `url` and filters the response with the JSONPath `path` to
-// retrieve a list of available versions.
+// ResolveVersion returns the latest release that matches the given spec.
+// It queries the `url` and extracts a list of available versions from the
+// response as described by `query`.
 // The `spec` constraints are then used to determine the latest version.
-// If no url is given, the spec is returned as-is.
+// If no url is given, the spec constraints are returned as-is.
+//
+// Releases that `accept`, if given, rejects with an error are skipped, e.g.
+// because they lack the asset for the host platform.
 //
 // This function implements lazy pagination with early termination: it fetches
 // pages one at a time and returns as soon as a matching version is found.
-// We assume APIs return versions/releases newest-first, this avoids fetching
-// all pages when the latest version matches the constraint.
-func ResolveVersion(ctx context.Context, client *http.Client, url string, path string, spec string, prefix string) (string, error) {
+// This assumes APIs return versions/releases newest-first, which avoids
+// fetching all pages when the latest version matches the constraint. Listings
+// with the `unsorted` query order are read completely instead, see
+// GetReleases.
+func ResolveVersion(ctx context.Context, client *http.Client, url string, query VersionsQuery, spec VersionSpec, accept func(Release) error) (Release, error) {
 	if url == "" {
-		return spec, nil
+		// the constraints are not necessarily versions, e.g. `nightly`
+		extractor, err := newVersionExtractor(spec)
+		if err != nil {
+			return Release{}, err
+		}
+		return extractor.release(spec.Constraints), nil
 	}
 
-	// Parse constraints once
-	constraintSpec := spec
-	if constraintSpec == "" || constraintSpec == "latest" {
-		constraintSpec = "*"
-	}
-	constraints, err := semver.NewConstraint(strings.TrimPrefix(constraintSpec, prefix))
+	matcher, err := newVersionMatcher(spec)
 	if err != nil {
-		return "", fmt.Errorf("parse version constraints: %w", err)
+		return Release{}, err
 	}
 
-	// Paginate and check each page with early termination
-	for {
-		resp, err := client.Get(url)
-		if err != nil {
-			return "", err
-		}
-		defer func() {
-			_ = resp.Body.Close()
-		}()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return "", metaerr.WithMetadata(
-				fmt.Errorf("%d - %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
-				"body", string(body),
-			)
-		}
+	accept, skipped := trackSkipped(accept)
 
-		body, err := io.ReadAll(resp.Body)
+	switch query.Order {
+	case "", versionsOrderNewestFirst:
+	case versionsOrderUnsorted:
+		releases, err := GetReleases(ctx, client, url, query)
 		if err != nil {
-			return "", fmt.Errorf("read response body: %w", err)
+			return Release{}, err
 		}
-
-		var src any
-		if err := json.Unmarshal(body, &src); err != nil {
-			return "", fmt.Errorf("unmarshal response body: %w", err)
+		if latest, ok := matcher.latest(releases, accept); ok {
+			return latest, nil
 		}
+		return Release{}, noMatchingVersions(spec, *skipped)
+	default:
+		return Release{}, fmt.Errorf("unsupported versions order: %s", query.Order)
+	}
 
-		rawVersions, err := retrieveVersions(src, path)
+	// Paginate and check each page with early termination
+	for {
+		body, header, err := fetch(ctx, client, url)
 		if err != nil {
-			return "", metaerr.WithMetadata(fmt.Errorf("retrieve versions: %w", err), "jsonpath", path)
+			return Release{}, err
 		}
 
-		// Parse and filter versions on this page
-		var pageVersions []*semver.Version
-		for _, raw := range rawVersions {
-			v, err := semver.NewVersion(strings.TrimPrefix(raw, prefix))
-			if err != nil {
-				continue
-			}
-			if constraints.Check(v) {
-				pageVersions = append(pageVersions, v)
-			}
+		releases, err := query.Releases(body)
+		if err != nil {
+			return Release{}, metaerr.WithMetadata(fmt.Errorf("retrieve versions: %w", err), "query", query)
 		}
 
 		// If we found matches on this page, return the highest one
-		if len(pageVersions) > 0 {
-			sort.Sort(sort.Reverse(semver.Collection(pageVersions)))
-			return prefix + pageVersions[0].Original(), nil
+		if latest, ok := matcher.latest(releases, accept); ok {
+			return latest, nil
 		}
 
 		// No match on this page, try next
-		nextLink := findNextLink(resp.Header.Values("Link"))
+		nextLink := nextPageURL(url, header)
 		if nextLink == "" {
 			break
 		}
 		url = nextLink
 	}
 
-	return "", fmt.Errorf("no matching versions: %v", spec)
+	return Release{}, noMatchingVersions(spec, *skipped)
 }
 
-// GetVersions queries the `url` and filters the response using the JSONPath
-// `path` to get a list of versions.
-func GetVersions(ctx context.Context, client *http.Client, url string, path string) ([]string, error) {
-	var versions []string
-
-	for {
-		resp, err := client.Get(url)
+// trackSkipped wraps `accept` to record the tags of rejected releases.
+func trackSkipped(accept func(Release) error) (func(Release) error, *[]string) {
+	skipped := new([]string)
+	if accept == nil {
+		return nil, skipped
+	}
+	return func(release Release) error {
+		err := accept(release)
 		if err != nil {
-			return nil, err
+			*skipped = append(*skipped, release.Tag)
 		}
-		defer func() {
-			_ = resp.Body.Close()
-		}()
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
+		return err
+	}, skipped
+}
+
+func noMatchingVersions(spec VersionSpec, skipped []string) error {
+	if len(skipped) > 0 {
+		return metaerr.WithMetadata(
+			fmt.Errorf("no matching versions: %v (%d skipped)", spec.Constraints, len(skipped)),
+			"skipped", skipped,
+		)
+	}
+	return fmt.Errorf("no matching versions: %v", spec.Constraints)
+}
+
+// maxVersionsPages limits the number of pages that are read from a versions
+// endpoint, in case its pagination never ends.
+const maxVersionsPages = 100
+
+// GetVersions queries the `url` and extracts the versions from the response
+// as described by `query`.
+func GetVersions(ctx context.Context, client *http.Client, url string, query VersionsQuery) ([]string, error) {
+	releases, err := GetReleases(ctx, client, url, query)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(releases))
+	for _, release := range releases {
+		versions = append(versions, release.Tag)
+	}
+	return versions, nil
+}
+
+// GetReleases queries the `url` and extracts the releases from the response
+// as described by `query`. It follows the pagination of the response for up
+// to maxVersionsPages pages.
+func GetReleases(ctx context.Context, client *http.Client, url string, query VersionsQuery) ([]Release, error) {
+	var releases []Release
+
+	for page := 1; ; page++ {
+		if page > maxVersionsPages {
 			return nil, metaerr.WithMetadata(
-				fmt.Errorf("%d - %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
-				"body", string(body),
+				fmt.Errorf("more than %d pages of versions", maxVersionsPages),
+				"url", url,
 			)
 		}
 
-		body, err := io.ReadAll(resp.Body)
+		body, header, err := fetch(ctx, client, url)
 		if err != nil {
-			return nil, fmt.Errorf("read response body: %w", err)
-		}
-
-		var src any
-		if err := json.Unmarshal(body, &src); err != nil {
-			return nil, fmt.Errorf("unmarshal response body: %w", err)
+			return nil, err
 		}
 
-		vs, err := retrieveVersions(src, path)
+		rs, err := query.Releases(body)
 		if err != nil {
-			return nil, err
+			return nil, metaerr.WithMetadata(fmt.Errorf("retrieve versions: %w", err), "query", query)
 		}
-		versions = append(versions, vs...)
+		releases = append(releases, rs...)
 
-		nextLink := findNextLink(resp.Header.Values("Link"))
+		nextLink := nextPageURL(url, header)
 		if nextLink == "" {
 			break
 		}
 		url = nextLink
 	}
 
-	return versions, nil
+	return releases, nil
 }
 
-// FindLatestVersion returns the latest version from the list of `versions`
-// that matches the given constraints `spec`.
-func FindLatestVersion(versions []string, spec string, prefix string) (string, error) {
-	if spec == "" || spec == "latest" {
-		spec = "*"
+// fetch retrieves the given url and returns the response body and header.
+// Responses with a status other than `200 OK` are returned as errors.
+func fetch(ctx context.Context, client *http.Client, url string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
 	}
-	constraints, err := semver.NewConstraint(strings.TrimPrefix(spec, prefix))
+	return fetchRequest(client, req)
+}
+
+// fetchRequest is like fetch, but sends the given request.
+func fetchRequest(client *http.Client, req *http.Request) ([]byte, http.Header, error) {
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, nil, metaerr.WithMetadata(
+			fmt.Errorf("%d - %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+			"status", resp.StatusCode,
+			"body", string(body),
+		)
 	}
 
-	vs := make([]*semver.Version, 0, len(versions))
-	for _, raw := range versions {
-		v, err := semver.NewVersion(strings.TrimPrefix(raw, prefix))
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read response body: %w", err)
+	}
+
+	return body, resp.Header, nil
+}
+
+// FindLatestVersion returns the latest release from the list of `versions`
+// that matches the given spec and that `accept`, if given, doesn't reject.
+func FindLatestVersion(versions []string, spec VersionSpec, accept func(Release) error) (Release, error) {
+	return FindLatestRelease(tagReleases(versions), spec, accept)
+}
+
+// FindLatestRelease is like FindLatestVersion, but for a list of releases.
+func FindLatestRelease(releases []Release, spec VersionSpec, accept func(Release) error) (Release, error) {
+	matcher, err := newVersionMatcher(spec)
+	if err != nil {
+		return Release{}, err
+	}
+	accept, skipped := trackSkipped(accept)
+	latest, ok := matcher.latest(releases, accept)
+	if !ok {
+		return Release{}, noMatchingVersions(spec, *skipped)
+	}
+	return latest, nil
+}
+
+// Release is a resolved version. Tag is the version as published, e.g.
+// `jq-1.7.1`, and Version is the version extracted from it, e.g. `1.7.1`.
+type Release struct {
+	Tag     string
+	Version string
+	// Prerelease and Draft are set if the provider marks the release as
+	// prerelease or as not yet published, respectively.
+	Prerelease bool
+	Draft      bool
+	// PublishedAt is the publication time, if known.
+	PublishedAt time.Time
+	// Assets are the names or urls of the release's assets, or nil if they
+	// are unknown.
+	Assets []string
+}
+
+// hasAsset reports whether the release has an asset with the given name.
+// Releases with unknown assets are assumed to have it.
+func (r Release) hasAsset(name string) bool {
+	if r.Assets == nil {
+		return true
+	}
+	return slices.ContainsFunc(r.Assets, func(asset string) bool {
+		return asset == name || strings.HasSuffix(asset, "/"+name)
+	})
+}
+
+// templateData returns the values available in templates rendered for the
+// release. `.Version` is the published version, i.e. the tag as-is, so that
+// existing templates keep rendering the same. `.Tag` is an alias of it for
+// templates that want to make this explicit, and `.VersionCore` is the
+// version extracted with the VersionSpec's prefix or pattern.
+func (r Release) templateData(data ProviderData) map[string]any {
+	return map[string]any{
+		"Provider":    data,
+		"Version":     r.Tag,
+		"Tag":         r.Tag,
+		"VersionCore": r.Version,
+	}
+}
+
+// versionMatcher extracts the versions from published versions and matches
+// them against the constraints of a VersionSpec.
+type versionMatcher struct {
+	prefix      string
+	pattern     *regexp.Regexp
+	group       int
+	scheme      versionScheme
+	constraints versionCheck
+	// channel is the least stable channel that is accepted, prereleases are
+	// accepted regardless if the constraints explicitly ask for them.
+	channel     int
+	prereleases bool
+}
+
+func newVersionMatcher(spec VersionSpec) (*versionMatcher, error) {
+	m, err := newVersionExtractor(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, err := newVersionScheme(spec.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	m.scheme = scheme
+
+	switch {
+	case spec.IncludePrereleases:
+		m.channel = channelNightly
+	default:
+		m.channel = slices.Index(releaseChannels, cmp.Or(spec.Channel, releaseChannels[channelStable]))
+		if m.channel < 0 {
+			return nil, fmt.Errorf("unsupported release channel: %s", spec.Channel)
+		}
+	}
+
+	constraints := spec.Constraints
+	if constraints == "" || constraints == "latest" {
+		constraints = "*"
+	}
+	constraints = strings.TrimPrefix(constraints, spec.Prefix)
+	m.prereleases = prereleaseConstraintPattern.MatchString(constraints)
+	c, err := scheme.parseConstraints(constraints, m.channel > channelStable)
+	if err != nil {
+		return nil, fmt.Errorf("parse version constraints: %w", err)
+	}
+	m.constraints = c
+
+	return m, nil
+}
+
+// newVersionExtractor returns a matcher that only extracts versions, i.e.
+// without constraints.
+func newVersionExtractor(spec VersionSpec) (*versionMatcher, error) {
+	m := &versionMatcher{prefix: spec.Prefix}
+
+	if spec.Pattern != "" {
+		re, err := regexp.Compile(spec.Pattern)
 		if err != nil {
+			return nil, fmt.Errorf("parse version pattern: %w", err)
+		}
+		m.pattern = re
+		m.group = captureGroup(re)
+	}
+	return m, nil
+}
+
+// extract returns the version core of a published version.
+func (m *versionMatcher) extract(raw string) (string, bool) {
+	if m.pattern == nil {
+		return strings.TrimPrefix(raw, m.prefix), true
+	}
+	match := m.pattern.FindStringSubmatch(raw)
+	if match == nil || match[m.group] == "" {
+		return "", false
+	}
+	return match[m.group], true
+}
+
+// release returns the release of a published version.
+func (m *versionMatcher) release(raw string) Release {
+	core, ok := m.extract(raw)
+	if !ok {
+		core = raw
+	}
+	return Release{Tag: raw, Version: core}
+}
+
+// latest returns the highest of the versions that match the constraints and
+// that `accept`, if given, doesn't reject. Versions that can't be parsed are
+// skipped.
+func (m *versionMatcher) latest(releases []Release, accept func(Release) error) (Release, bool) {
+	for _, release := range m.matches(releases) {
+		if accept == nil || accept(release) == nil {
+			return release, true
+		}
+	}
+	return Release{}, false
+}
+
+// matches returns the releases that match the constraints, highest first.
+func (m *versionMatcher) matches(releases []Release) []Release {
+	type match struct {
+		release Release
+		version orderedVersion
+	}
+	var matches []match
+	for _, release := range releases {
+		if release.Draft {
 			continue
 		}
-		if !constraints.Check(v) {
+		core, ok := m.extract(release.Tag)
+		if !ok {
 			continue
 		}
-		vs = append(vs, v)
-	}
-	if len(vs) == 0 {
-		return "", fmt.Errorf("no matching versions: %v", spec)
+		v, err := m.scheme.parse(core)
+		if err != nil || !m.constraints(v) {
+			continue
+		}
+		if !m.prereleases && releaseChannel(release, core, v) > m.channel {
+			continue
+		}
+		release.Version = core
+		matches = append(matches, match{release: release, version: v})
 	}
+	slices.SortStableFunc(matches, func(a, b match) int {
+		return b.version.compare(a.version)
+	})
 
-	sort.Sort(sort.Reverse(semver.Collection(vs)))
-	latest := prefix + vs[0].Original()
-	return latest, nil
+	result := make([]Release, 0, len(matches))
+	for _, m := range matches {
+		result = append(result, m.release)
+	}
+	return result
 }
 
-func retrieveVersions(src any, path string) ([]string, error) {
-	config := jsonpath.Config{}
-	config.SetAccessorMode()
+const (
+	channelStable = iota
+	channelRC
+	channelBeta
+	channelAlpha
+	channelNightly
+)
 
-	results, err := jsonpath.Retrieve(path, src, config)
-	if err != nil {
-		return nil, err
+// releaseChannels are the release channels from most to least stable. Each
+// channel includes the more stable ones.
+var releaseChannels = []string{"stable", "rc", "beta", "alpha", "nightly"}
+
+var (
+	// prereleasePattern matches the channel in prerelease identifiers like
+	// `rc.1`, `beta2` or `nightly-20240601`.
+	prereleasePattern = regexp.MustCompile(`(?i)(?:^|[^a-z])(rc|cr|beta|pre|preview|alpha|dev|nightly|snapshot|canary|edge)(?:[^a-z]|$)`)
+	// prereleaseConstraintPattern matches constraints on prerelease versions
+	// like `>=2.0.0-rc.1`.
+	prereleaseConstraintPattern = regexp.MustCompile(`\d-[0-9A-Za-z]`)
+)
+
+// releaseChannel returns the channel of the release, based on its version
+// and the provider's prerelease flag. Prereleases of unknown channels are
+// considered beta releases.
+func releaseChannel(release Release, core string, v orderedVersion) int {
+	identifier := core
+	if sv, ok := v.(semverVersion); ok {
+		if sv.Prerelease() == "" && !release.Prerelease {
+			return channelStable
+		}
+		identifier = sv.Prerelease()
 	}
 
-	var versions []string
-	for _, result := range results {
-		version := result.(jsonpath.Accessor).Get().(string)
-		if version == "" {
-			continue
+	m := prereleasePattern.FindStringSubmatch(identifier)
+	if m == nil {
+		if _, ok := v.(semverVersion); ok || release.Prerelease {
+			return channelBeta // e.g. `1.0.0-0.3.7`
 		}
-		versions = append(versions, version)
+		return channelStable
+	}
+	switch strings.ToLower(m[1]) {
+	case "rc", "cr":
+		return channelRC
+	case "beta", "pre", "preview":
+		return channelBeta
+	case "alpha":
+		return channelAlpha
+	default:
+		return channelNightly
 	}
+}
 
-	return versions, nil
+// nextPageURL returns the url of the next page given by the Link header of
+// the response to `current`. Relative links are resolved against `current`.
+func nextPageURL(current string, header http.Header) string {
+	link := findNextLink(header.Values("Link"))
+	if link == "" {
+		return ""
+	}
+	base, err := _url.Parse(current)
+	if err != nil {
+		return link
+	}
+	ref, err := _url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
 }
 
 func findNextLink(headers []string) string {
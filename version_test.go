@@ -50,7 +50,7 @@ func TestGetVersions(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.testName, func(t *testing.T) {
-			got, gotErr := GetVersions(context.Background(), http.DefaultClient, tt.url, tt.path)
+			got, gotErr := GetVersions(context.Background(), http.DefaultClient, tt.url, VersionsQuery{JSONPath: tt.path})
 			if gotErr != nil {
 				if !tt.wantErr {
 					t.Errorf("GetVersions() failed: %v", gotErr)
@@ -111,7 +111,7 @@ func TestGetVersionsPaginated(t *testing.T) {
 	}
 
 	t.Run(tt.testName, func(t *testing.T) {
-		got, gotErr := GetVersions(context.Background(), http.DefaultClient, tt.url, tt.path)
+		got, gotErr := GetVersions(context.Background(), http.DefaultClient, tt.url, VersionsQuery{JSONPath: tt.path})
 		if gotErr != nil {
 			if !tt.wantErr {
 				t.Errorf("GetVersionsPaginated() failed: %v", gotErr)
@@ -134,7 +134,9 @@ func TestFindLatestVersion(t *testing.T) {
 		versions    []string
 		constraints string
 		prefix      string
+		pattern     string
 		want        string
+		wantCore    string
 		wantErr     bool
 	}{
 		{
@@ -163,10 +165,53 @@ func TestFindLatestVersion(t *testing.T) {
 			want:        "0.1.0",
 			wantErr:     false,
 		},
+		{
+			testName:    "pattern",
+			versions:    []string{"jq-1.7.1", "jq-1.6", "jq-1.8.0rc1"},
+			constraints: "latest",
+			pattern:     `^jq-(\d+\.\d+(?:\.\d+)?)$`,
+			want:        "jq-1.7.1",
+			wantCore:    "1.7.1",
+		},
+		{
+			testName:    "pattern with monorepo tags",
+			versions:    []string{"cli/v2.3.0", "lib/v3.0.0", "cli/v2.2.1"},
+			constraints: "^2.0",
+			pattern:     `^cli/v(?P<version>.+)$`,
+			want:        "cli/v2.3.0",
+			wantCore:    "2.3.0",
+		},
+		{
+			testName:    "pattern with build metadata",
+			versions:    []string{"v1.2.3+build.5", "v1.2.2"},
+			constraints: "~1.2",
+			pattern:     `^v(\d+\.\d+\.\d+)`,
+			want:        "v1.2.3+build.5",
+			wantCore:    "1.2.3",
+		},
+		{
+			testName:    "pattern with dates",
+			versions:    []string{"release-2024.05.01", "release-2024.11.20", "nightly"},
+			constraints: "<2024.10",
+			pattern:     `^release-(.+)$`,
+			want:        "release-2024.05.01",
+			wantCore:    "2024.05.01",
+		},
+		{
+			testName:    "invalid pattern",
+			versions:    []string{"v1.0.0"},
+			constraints: "latest",
+			pattern:     `(`,
+			wantErr:     true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.testName, func(t *testing.T) {
-			got, gotErr := FindLatestVersion(tt.versions, tt.constraints, tt.prefix)
+			got, gotErr := FindLatestVersion(tt.versions, VersionSpec{
+				Prefix:      tt.prefix,
+				Pattern:     tt.pattern,
+				Constraints: tt.constraints,
+			}, nil)
 			if gotErr != nil {
 				if !tt.wantErr {
 					t.Errorf("FindLatestVersion() failed: %v", gotErr)
@@ -176,20 +221,38 @@ func TestFindLatestVersion(t *testing.T) {
 			if tt.wantErr {
 				t.Fatal("FindLatestVersion() succeeded unexpectedly")
 			}
-			// TODO: update the condition below to compare got with tt.want.
-			if got != tt.want {
-				t.Errorf("FindLatestVersion() = %v, want %v", got, tt.want)
+			if got.Tag != tt.want {
+				t.Errorf("FindLatestVersion() = %v, want %v", got.Tag, tt.want)
+			}
+			if tt.wantCore != "" && got.Version != tt.wantCore {
+				t.Errorf("FindLatestVersion() version = %v, want %v", got.Version, tt.wantCore)
 			}
 		})
 	}
 }
 
+func TestGetReleasesPageLimit(t *testing.T) {
+	mux, srv := setupServer(t)
+	mux.HandleFunc("GET /releases", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Link", fmt.Sprintf(`<%s/releases?page=%d>; rel="next"`, srv.URL, page+1))
+		_ = json.NewEncoder(w).Encode([]map[string]string{{"tag_name": fmt.Sprintf("v0.%d.0", page)}})
+	})
+
+	_, err := GetReleases(context.Background(), srv.Client(), srv.URL+"/releases", VersionsQuery{JSONPath: "$[*].tag_name"})
+	if err == nil {
+		t.Fatal("GetReleases() succeeded unexpectedly")
+	}
+}
+
 func TestResolveVersion(t *testing.T) {
 	tests := []struct {
 		testName      string
 		pages         [][]string // pages of versions; nil means no server needed
 		spec          string
 		prefix        string
+		order         string
 		want          string
 		wantErr       bool
 		wantCallCount *int // if non-nil, verify the number of API calls
@@ -231,6 +294,17 @@ func TestResolveVersion(t *testing.T) {
 			prefix:   "v",
 			want:     "v1.2.3",
 		},
+		{
+			testName: "empty URL with non-version spec",
+			spec:     "nightly",
+			want:     "nightly",
+		},
+		{
+			testName: "empty URL with branch spec",
+			spec:     "main",
+			prefix:   "v",
+			want:     "main",
+		},
 		{
 			testName: "latest spec",
 			pages:    [][]string{{"v2.0.0", "v1.0.0"}},
@@ -238,6 +312,31 @@ func TestResolveVersion(t *testing.T) {
 			prefix:   "v",
 			want:     "v2.0.0",
 		},
+		{
+			testName:      "unsorted reads all pages",
+			pages:         [][]string{{"v1.9.0", "v2.0.0"}, {"v2.0.1", "v1.8.1"}, {"v1.0.0"}},
+			spec:          "^2.0.0",
+			prefix:        "v",
+			order:         "unsorted",
+			want:          "v2.0.1",
+			wantCallCount: ptr(3),
+		},
+		{
+			testName: "unsorted no matching version",
+			pages:    [][]string{{"v1.0.0"}, {"v1.1.0"}},
+			spec:     "^2.0.0",
+			prefix:   "v",
+			order:    "unsorted",
+			wantErr:  true,
+		},
+		{
+			testName: "unsupported order",
+			pages:    [][]string{{"v1.0.0"}},
+			spec:     "latest",
+			prefix:   "v",
+			order:    "oldest-first",
+			wantErr:  true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -278,9 +377,9 @@ func TestResolveVersion(t *testing.T) {
 				context.Background(),
 				client,
 				url,
-				"$[*].tag_name",
-				tt.spec,
-				tt.prefix,
+				VersionsQuery{JSONPath: "$[*].tag_name", Order: tt.order},
+				VersionSpec{Prefix: tt.prefix, Constraints: tt.spec},
+				nil,
 			)
 
 			if gotErr != nil {
@@ -292,8 +391,8 @@ func TestResolveVersion(t *testing.T) {
 			if tt.wantErr {
 				t.Fatal("ResolveVersion() succeeded unexpectedly")
 			}
-			if got != tt.want {
-				t.Errorf("ResolveVersion() = %v, want %v", got, tt.want)
+			if got.Tag != tt.want {
+				t.Errorf("ResolveVersion() = %v, want %v", got.Tag, tt.want)
 			}
 			if tt.wantCallCount != nil && callCount != *tt.wantCallCount {
 				t.Errorf("expected %d API calls, got %d", *tt.wantCallCount, callCount)
@@ -301,3 +400,97 @@ func TestResolveVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestRelease_templateData(t *testing.T) {
+	m, err := newVersionExtractor(VersionSpec{Pattern: `^jq-(\d+\.\d+\.\d+)$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := m.release("jq-1.7.1")
+
+	got, err := renderTemplate(
+		"{{ .Version }} {{ .Tag }} {{ .VersionCore }}",
+		release.templateData(ProviderData{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// .Version keeps rendering the published tag
+	if want := "jq-1.7.1 jq-1.7.1 1.7.1"; got != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+}
+
+func TestVersionMatcherChannel(t *testing.T) {
+	releases := []Release{
+		{Tag: "v2.0.0-nightly.20240601"},
+		{Tag: "v2.0.0-alpha.1"},
+		{Tag: "v1.6.0-beta.2"},
+		{Tag: "v1.5.0-rc.1"},
+		{Tag: "v1.4.0"},
+	}
+
+	tests := []struct {
+		testName string
+		spec     VersionSpec
+		releases []Release
+		want     string
+		wantErr  bool
+	}{
+		{testName: "default", want: "v1.4.0"},
+		{testName: "stable", spec: VersionSpec{Channel: "stable"}, want: "v1.4.0"},
+		{testName: "rc", spec: VersionSpec{Channel: "rc"}, want: "v1.5.0-rc.1"},
+		{testName: "beta", spec: VersionSpec{Channel: "beta"}, want: "v1.6.0-beta.2"},
+		{testName: "alpha", spec: VersionSpec{Channel: "alpha"}, want: "v2.0.0-alpha.1"},
+		{testName: "nightly", spec: VersionSpec{Channel: "nightly"}, want: "v2.0.0-nightly.20240601"},
+		{testName: "include prereleases", spec: VersionSpec{IncludePrereleases: true}, want: "v2.0.0-nightly.20240601"},
+		{testName: "constraints", spec: VersionSpec{Channel: "beta", Constraints: "<1.6.0-0"}, want: "v1.5.0-rc.1"},
+		{
+			testName: "flagged prerelease",
+			releases: []Release{{Tag: "v1.1.0", Prerelease: true}, {Tag: "v1.0.0"}},
+			want:     "v1.0.0",
+		},
+		{
+			testName: "flagged prerelease in beta channel",
+			spec:     VersionSpec{Channel: "beta"},
+			releases: []Release{{Tag: "v1.1.0", Prerelease: true}, {Tag: "v1.0.0"}},
+			want:     "v1.1.0",
+		},
+		{
+			testName: "draft",
+			spec:     VersionSpec{IncludePrereleases: true},
+			releases: []Release{{Tag: "v1.1.0", Draft: true}, {Tag: "v1.0.0"}},
+			want:     "v1.0.0",
+		},
+		{
+			testName: "calver nightly",
+			spec:     VersionSpec{Scheme: "calver"},
+			releases: []Release{{Tag: "2024.06.01.nightly"}, {Tag: "2024.05.27"}},
+			want:     "2024.05.27",
+		},
+		{testName: "unsupported channel", spec: VersionSpec{Channel: "edge"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			m, err := newVersionMatcher(tt.spec)
+			if err != nil {
+				if !tt.wantErr {
+					t.Fatalf("newVersionMatcher() failed: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Fatal("newVersionMatcher() succeeded unexpectedly")
+			}
+
+			rs := tt.releases
+			if rs == nil {
+				rs = releases
+			}
+			got, ok := m.latest(rs, nil)
+			if !ok || got.Tag != tt.want {
+				t.Errorf("latest() = %q, %v, want %q", got.Tag, ok, tt.want)
+			}
+		})
+	}
+}